@@ -0,0 +1,230 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "[redacted]"
+
+// The JSON or YAML config file. Only the settings present in the file override the defaults
+type fileConfig struct {
+	DatabaseDSN         *string `json:"database_dsn,omitempty" yaml:"database_dsn,omitempty"`
+	ServerAddress       *string `json:"server_address,omitempty" yaml:"server_address,omitempty"`
+	GRPCAddress         *string `json:"grpc_address,omitempty" yaml:"grpc_address,omitempty"`
+	BaseURL             *string `json:"base_url,omitempty" yaml:"base_url,omitempty"`
+	FileStoragePath     *string `json:"file_storage_path,omitempty" yaml:"file_storage_path,omitempty"`
+	SecretKey           *string `json:"secret_key,omitempty" yaml:"secret_key,omitempty"`
+	SweepInterval       *string `json:"sweep_interval,omitempty" yaml:"sweep_interval,omitempty"`
+	QueryTimeout        *string `json:"query_timeout,omitempty" yaml:"query_timeout,omitempty"`
+	ShutdownTimeout     *string `json:"shutdown_timeout,omitempty" yaml:"shutdown_timeout,omitempty"`
+	FsyncPolicy         *string `json:"fsync_policy,omitempty" yaml:"fsync_policy,omitempty"`
+	FsyncInterval       *string `json:"fsync_interval,omitempty" yaml:"fsync_interval,omitempty"`
+	CompactInterval     *string `json:"compact_interval,omitempty" yaml:"compact_interval,omitempty"`
+	LogLevel            *string `json:"log_level,omitempty" yaml:"log_level,omitempty"`
+	EnableHTTPS         *bool   `json:"enable_https,omitempty" yaml:"enable_https,omitempty"`
+	TLSCertFile         *string `json:"tls_cert_file,omitempty" yaml:"tls_cert_file,omitempty"`
+	TLSKeyFile          *string `json:"tls_key_file,omitempty" yaml:"tls_key_file,omitempty"`
+	HTTPRedirectAddress *string `json:"http_redirect_address,omitempty" yaml:"http_redirect_address,omitempty"`
+	RateLimit           *int    `json:"rate_limit,omitempty" yaml:"rate_limit,omitempty"`
+	RateBurst           *int    `json:"rate_burst,omitempty" yaml:"rate_burst,omitempty"`
+	RateLimitStore      *string `json:"rate_limit_store,omitempty" yaml:"rate_limit_store,omitempty"`
+	TrustedSubnet       *string `json:"trusted_subnet,omitempty" yaml:"trusted_subnet,omitempty"`
+	TrustedProxies      *string `json:"trusted_proxies,omitempty" yaml:"trusted_proxies,omitempty"`
+	AdminUsers          *string `json:"admin_users,omitempty" yaml:"admin_users,omitempty"`
+	KeyGenerator        *string `json:"key_generator,omitempty" yaml:"key_generator,omitempty"`
+	KeyLength           *int    `json:"key_length,omitempty" yaml:"key_length,omitempty"`
+	KeyAlphabet         *string `json:"key_alphabet,omitempty" yaml:"key_alphabet,omitempty"`
+	DB                  *fileDB `json:"db,omitempty" yaml:"db,omitempty"`
+}
+
+type fileDB struct {
+	User     *string `json:"user,omitempty" yaml:"user,omitempty"`
+	Password *string `json:"password,omitempty" yaml:"password,omitempty"`
+	Host     *string `json:"host,omitempty" yaml:"host,omitempty"`
+	Port     *string `json:"port,omitempty" yaml:"port,omitempty"`
+	DBname   *string `json:"dbname,omitempty" yaml:"dbname,omitempty"`
+	SSLmode  *string `json:"sslmode,omitempty" yaml:"sslmode,omitempty"`
+}
+
+// Apply the config file, the format is picked by the extension: .json, .yaml or .yml
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("couldn't read the config file: %w", err)
+	}
+
+	var file fileConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&file); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	default:
+		return ErrInvalidConfigFormat
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't parse the config file %s: %w", path, err)
+	}
+
+	return file.apply()
+}
+
+// Validate and apply every setting of the file, all the invalid ones are reported at once
+func (f fileConfig) apply() error {
+	var errs []error
+	set := func(name string, value *string, setter func(string) error) {
+		if value == nil {
+			return
+		}
+		if err := setter(*value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	// The numbers are checked the same way as the ones of the flags and the environment
+	setNumber := func(name string, value *int, field *int) {
+		if value == nil {
+			return
+		}
+		number := strconv.Itoa(*value)
+		set(name, &number, setCount(field))
+	}
+
+	set("database_dsn", f.DatabaseDSN, clearableDSN)
+	set("server_address", f.ServerAddress, setListenAddress)
+	set("grpc_address", f.GRPCAddress, optional(setGRPCAddress, &config.GRPCAddress))
+	set("base_url", f.BaseURL, optional(setURL, &config.BaseURL))
+	set("file_storage_path", f.FileStoragePath, setString(&config.StorageFile))
+	set("secret_key", f.SecretKey, setString(&config.SecretKey))
+	set("sweep_interval", f.SweepInterval, setDuration(&config.SweepInterval))
+	set("query_timeout", f.QueryTimeout, setDuration(&config.QueryTimeout))
+	set("shutdown_timeout", f.ShutdownTimeout, setDuration(&config.ShutdownTimeout))
+	set("fsync_policy", f.FsyncPolicy, setFsyncPolicy)
+	set("fsync_interval", f.FsyncInterval, setDuration(&config.FsyncInterval))
+	set("compact_interval", f.CompactInterval, setDuration(&config.CompactInterval))
+	set("log_level", f.LogLevel, setLogLevel)
+	set("tls_cert_file", f.TLSCertFile, setString(&config.TLSCertFile))
+	set("tls_key_file", f.TLSKeyFile, setString(&config.TLSKeyFile))
+	set("http_redirect_address", f.HTTPRedirectAddress, optional(setRedirectAddress, &config.HTTPRedirectAddress))
+	set("rate_limit_store", f.RateLimitStore, setRateLimitStore)
+	set("trusted_subnet", f.TrustedSubnet, optional(setTrustedSubnet, &config.TrustedSubnet))
+	set("trusted_proxies", f.TrustedProxies, setTrustedProxies)
+	set("admin_users", f.AdminUsers, setString(&config.AdminUsers))
+	set("key_generator", f.KeyGenerator, setString(&config.KeyGenerator))
+	set("key_alphabet", f.KeyAlphabet, setString(&config.KeyAlphabet))
+	if f.EnableHTTPS != nil {
+		config.EnableHTTPS = *f.EnableHTTPS
+	}
+	setNumber("rate_limit", f.RateLimit, &config.RateLimit)
+	setNumber("rate_burst", f.RateBurst, &config.RateBurst)
+	setNumber("key_length", f.KeyLength, &config.KeyLength)
+
+	if db := f.DB; db != nil {
+		set("db.user", db.User, setString(&config.DB.User))
+		set("db.password", db.Password, setString(&config.DB.Password))
+		set("db.host", db.Host, setString(&config.DB.Host))
+		set("db.port", db.Port, optional(setDBPort, &config.DB.Port))
+		set("db.dbname", db.DBname, setString(&config.DB.DBname))
+		set("db.sslmode", db.SSLmode, setString(&config.DB.SSLmode))
+		if config.DB.Host != "" && config.DB.Port == "" {
+			config.DB.Port = "5432"
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// The empty database_dsn unsets the storage DSN together with the database settings the DSN fills in,
+// otherwise the database would still be used
+func clearableDSN(value string) error {
+	if value != "" {
+		return parseDSN(value)
+	}
+
+	config.StorageURL = ""
+	config.DB = DB{SSLmode: config.DB.SSLmode}
+	return nil
+}
+
+// Write the configuration in the format of the YAML config file with the secrets redacted
+func (c Configuration) WriteRedacted(w io.Writer) error {
+	secret := func(value string) *string {
+		if value != "" {
+			value = redacted
+		}
+		return &value
+	}
+	duration := func(value fmt.Stringer) *string {
+		s := value.String()
+		return &s
+	}
+
+	dsn := c.StorageURL
+	if u, err := url.Parse(dsn); err == nil {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+			dsn = u.String()
+		}
+	}
+
+	file := fileConfig{
+		DatabaseDSN:         &dsn,
+		ServerAddress:       &c.ListenAddress,
+		GRPCAddress:         &c.GRPCAddress,
+		BaseURL:             &c.BaseURL,
+		FileStoragePath:     &c.StorageFile,
+		SecretKey:           secret(c.SecretKey),
+		SweepInterval:       duration(c.SweepInterval),
+		QueryTimeout:        duration(c.QueryTimeout),
+		ShutdownTimeout:     duration(c.ShutdownTimeout),
+		FsyncPolicy:         &c.FsyncPolicy,
+		FsyncInterval:       duration(c.FsyncInterval),
+		CompactInterval:     duration(c.CompactInterval),
+		LogLevel:            &c.LogLevel,
+		EnableHTTPS:         &c.EnableHTTPS,
+		TLSCertFile:         &c.TLSCertFile,
+		TLSKeyFile:          &c.TLSKeyFile,
+		HTTPRedirectAddress: &c.HTTPRedirectAddress,
+		RateLimit:           &c.RateLimit,
+		RateBurst:           &c.RateBurst,
+		RateLimitStore:      &c.RateLimitStore,
+		TrustedSubnet:       &c.TrustedSubnet,
+		TrustedProxies:      &c.TrustedProxies,
+		AdminUsers:          &c.AdminUsers,
+		KeyGenerator:        &c.KeyGenerator,
+		KeyLength:           &c.KeyLength,
+		KeyAlphabet:         &c.KeyAlphabet,
+		DB: &fileDB{
+			User:     &c.DB.User,
+			Password: secret(c.DB.Password),
+			Host:     &c.DB.Host,
+			Port:     &c.DB.Port,
+			DBname:   &c.DB.DBname,
+			SSLmode:  &c.DB.SSLmode,
+		},
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(file); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+var errConfigUsage = errors.New("usage: shortener [flags] config validate")
+
+// Print the effective configuration with the secrets redacted and report the invalid settings
+func runConfig(config conf.Configuration, configErr error, args []string) error {
+	if len(args) != 1 || args[0] != "validate" {
+		return errConfigUsage
+	}
+
+	if err := config.WriteRedacted(os.Stdout); err != nil {
+		return err
+	}
+	if err := storage.Validate(config.StorageDSN()); err != nil {
+		configErr = errors.Join(configErr, fmt.Errorf("storage: %w", err))
+	}
+	if configErr != nil {
+		return fmt.Errorf("the configuration is invalid:\n%w", configErr)
+	}
+
+	fmt.Fprintln(os.Stderr, "The configuration is valid")
+	return nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 
 	conf "github.com/nomardt/urlshortener-x/cmd/config"
@@ -9,10 +11,35 @@ import (
 
 func main() {
 	config, err := conf.LoadConfig()
+
+	// The config command reports the invalid settings itself
+	args := flag.Args()
+	if len(args) > 0 && args[0] == "config" {
+		if err := runConfig(config, err, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// The arguments left after the flags select a subcommand
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			err = runMigrate(config, args[1:])
+		case "storage":
+			err = runStorage(config, args[1:])
+		default:
+			err = fmt.Errorf("unknown command %q", args[0])
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if err := internal.Run(config); err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/internal/infra/migrations"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+)
+
+var errMigrateUsage = errors.New("usage: shortener [flags] migrate up|down|status")
+
+// Manage the database schema: apply the pending migrations, roll back the last one or show their state
+func runMigrate(config conf.Configuration, args []string) error {
+	if len(args) != 1 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+		return errMigrateUsage
+	}
+	dsn := config.StorageDSN()
+	if u, err := url.Parse(dsn); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return errors.New("please specify the PostgreSQL database to migrate with -d or DATABASE_DSN")
+	}
+
+	db, err := urlsInfra.OpenDB(dsn, config)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("The database is up to date")
+		}
+		for _, version := range applied {
+			fmt.Printf("Applied migration %04d\n", version)
+		}
+	case "down":
+		version, err := migrator.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back migration %04d\n", version)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errMigrateUsage
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+var errStorageUsage = errors.New("usage: shortener [flags] storage copy --from <dsn> --to <dsn> [--verify] [--after <key>]")
+
+// Manage the stored URLs regardless of the storage they are kept in
+func runStorage(config conf.Configuration, args []string) error {
+	if len(args) == 0 || args[0] != "copy" {
+		return errStorageUsage
+	}
+
+	return runStorageCopy(config, args[1:])
+}
+
+// Copy every URL from one storage to another, e.g. from the file to PostgreSQL. The interrupted copy is resumed
+// with --after, running it again from the start skips the URLs which are already copied
+func runStorageCopy(config conf.Configuration, args []string) error {
+	flags := flag.NewFlagSet("storage copy", flag.ContinueOnError)
+	from := flags.String("from", config.StorageDSN(), "The DSN of the storage to copy the URLs from")
+	to := flags.String("to", "", "The DSN of the storage to copy the URLs to")
+	verify := flags.Bool("verify", false, "Compare the numbers of the URLs and a sample of them once the URLs are copied")
+	after := flags.String("after", "", "Copy only the URLs with the keys after this one to resume the interrupted copy")
+	batchSize := flags.Int("batch", 500, "The number of URLs copied at once")
+	sampleSize := flags.Int("sample", 100, "The number of URLs compared by --verify")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *to == "" || flags.NArg() > 0 {
+		return errStorageUsage
+	}
+	if *from == *to {
+		return errors.New("the storage can't be copied to itself")
+	}
+
+	source, err := storage.Open(*from, config)
+	if err != nil {
+		return fmt.Errorf("couldn't open the source storage: %w", err)
+	}
+	defer source.Close()
+	destination, err := storage.Open(*to, config)
+	if err != nil {
+		return fmt.Errorf("couldn't open the destination storage: %w", err)
+	}
+	defer destination.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := storage.Copy(ctx, source, destination, storage.CopyOptions{After: *after, BatchSize: *batchSize})
+	for _, conflict := range report.Conflicts {
+		fmt.Fprintf(os.Stderr, "Conflict: %s -> %s (correlation ID %s): %v\n",
+			conflict.URL.Key, conflict.URL.OriginalURL, conflict.URL.CorrelationID, conflict.Err)
+	}
+	fmt.Printf("Copied %d URLs, %d were already there, %d conflicted\n", report.Copied, report.Existing, len(report.Conflicts))
+	if err != nil {
+		if report.LastKey != "" {
+			fmt.Fprintf(os.Stderr, "The copy was interrupted, resume it with --after %s\n", report.LastKey)
+		}
+		return err
+	}
+
+	if *verify {
+		verifyReport, err := storage.Verify(ctx, source, destination, *sampleSize, *batchSize)
+		if err != nil {
+			return fmt.Errorf("couldn't verify the copy: %w", err)
+		}
+		for _, mismatch := range verifyReport.Mismatches {
+			fmt.Fprintf(os.Stderr, "Mismatch: %s: %s\n", mismatch.Key, mismatch.Reason)
+		}
+		fmt.Printf("The source has %d URLs, the destination has %d, %d of %d sampled URLs differ\n",
+			verifyReport.SourceCount, verifyReport.DestinationCount, len(verifyReport.Mismatches), verifyReport.Sampled)
+		if !verifyReport.OK() {
+			return errors.New("the destination doesn't match the source")
+		}
+	}
+
+	if len(report.Conflicts) > 0 {
+		return fmt.Errorf("%d URLs conflict with the URLs in the destination", len(report.Conflicts))
+	}
+
+	return nil
+}
@@ -1,26 +1,36 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
 	"github.com/nomardt/urlshortener-x/internal/domain/urls"
 	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/metrics"
 	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
 	"go.uber.org/zap"
 )
 
 type requestShortenURL struct {
-	URL string `json:"url"`
+	URL       string     `json:"url"`
+	Alias     string     `json:"alias,omitempty"`
+	ExpiresIn int64      `json:"expires_in,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type requestShortenBatchURLs struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresIn     int64      `json:"expires_in,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
 }
 
 type responseShortenURL struct {
@@ -32,9 +42,17 @@ type responseShortenBatchURLs struct {
 	ShortURL      string `json:"short_url"`
 }
 
-func shortenURL(urlInput string, h *Handler, correlationID string) (string, error) {
+// The number of the generated keys tried before giving up on the taken ones
+const maxKeyAttempts = 5
+
+// Every generated key tried was already taken. It isn't a conflict, the client sent no alias
+var errKeyAllocation = errors.New("could not allocate a key, every generated key was already taken")
+
+// The alias is optional, if it is empty then the key is generated by the key generator.
+// The zero expiresAt means that the shortened URL never expires
+func newURL(keys urls.KeyGenerator, urlInput string, correlationID string, userID string, alias string, expiresAt time.Time) (*urls.URL, error) {
 	if urlInput == "" {
-		return "", errors.New("no URL provided")
+		return nil, urls.ErrInvalidURL
 	}
 
 	if correlationID == "" {
@@ -43,25 +61,167 @@ func shortenURL(urlInput string, h *Handler, correlationID string) (string, erro
 
 	var u *urls.URL
 	var err error
-	// If a key is predefined in config then store all shortened URLs at that path
-	if h.Configuration.Path == "" {
-		u, err = urls.NewURLWithoutKey(urlInput, correlationID)
+	if alias == "" {
+		u, err = urls.NewURLWithGeneratedKey(keys, urlInput, correlationID, userID)
 	} else {
-		u, err = urls.NewURL(urlInput, h.Configuration.Path, correlationID)
+		u, err = urls.NewURL(urlInput, alias, correlationID, userID)
 	}
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	u.SetExpiresAt(expiresAt)
 
-	err = h.SaveURL(u)
-	if err != nil {
+	return u, nil
+}
+
+func shortenURL(ctx context.Context, urlInput string, h *Handler, correlationID string, userID string, alias string, expiresAt time.Time) (string, error) {
+	var u *urls.URL
+	var err error
+	for attempt := 1; ; attempt++ {
+		if u, err = newURL(h.keys, urlInput, correlationID, userID, alias, expiresAt); err != nil {
+			return "", err
+		}
+
+		// The generated key is already taken, the next one is tried
+		err = h.SaveURL(ctx, u)
+		if alias != "" || attempt == maxKeyAttempts || !errors.Is(err, urlsInfra.ErrKeyNotUnique) {
+			break
+		}
+		metrics.KeyCollisions.Inc()
+	}
+
+	var errURINotUnique *urlsInfra.ErrURINotUnique
+	if errors.As(err, &errURINotUnique) {
+		metrics.LinkConflicts.WithLabelValues("url").Inc()
+		return "", err
+	} else if errors.Is(err, urlsInfra.ErrKeyNotUnique) && alias == "" {
+		logger.Log.Info("Couldn't allocate a free key", zap.Int("attempts", maxKeyAttempts))
+		return "", errKeyAllocation
+	} else if errors.Is(err, urlsInfra.ErrKeyNotUnique) {
+		metrics.LinkConflicts.WithLabelValues("key").Inc()
+		return "", err
+	} else if err != nil {
 		return "", err
 	}
+	metrics.LinksCreated.Inc()
 	logger.Log.Info("Shortened new URI", zap.String("data", urlInput), zap.String("date", time.Now().Format("2006/01/02")), zap.String("time", time.Now().Format("15:04:05")))
 
 	return u.ID(), nil
 }
 
+// Every URL is validated before saving anything so that the batch is saved either completely or not at all.
+// The returned keys are in the order of the URLs
+func shortenBatch(ctx context.Context, h *Handler, userID string, input []requestShortenBatchURLs) ([]string, error) {
+	batch := make([]*urls.URL, 0, len(input))
+	for _, url := range input {
+		expiresAt, err := parseExpiry(url.ExpiresIn, url.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		u, err := newURL(h.keys, url.OriginalURL, url.CorrelationID, userID, url.Alias, expiresAt)
+		if errors.Is(err, urls.ErrInvalidURL) {
+			return nil, fmt.Errorf("%w: %s", err, url.OriginalURL)
+		} else if err != nil {
+			return nil, err
+		}
+
+		batch = append(batch, u)
+	}
+
+	keys, err := h.SaveURLs(ctx, batch)
+	for attempt := 1; errors.Is(err, urlsInfra.ErrKeyNotUnique); attempt++ {
+		// Only the clash of a generated key is worth retrying, the taken alias is reported at once
+		taken, checkErr := aliasTaken(ctx, h, input)
+		if checkErr != nil {
+			return nil, checkErr
+		}
+		if taken || !hasGeneratedKeys(input) {
+			metrics.LinkConflicts.WithLabelValues("key").Inc()
+			return nil, err
+		}
+		if attempt == maxKeyAttempts {
+			logger.Log.Info("Couldn't allocate free keys for the batch", zap.Int("attempts", maxKeyAttempts))
+			return nil, errKeyAllocation
+		}
+
+		// The batch is saved either completely or not at all, so all the generated keys are replaced
+		if batch, err = regenerateKeys(h.keys, batch, input); err != nil {
+			return nil, err
+		}
+		metrics.KeyCollisions.Inc()
+		keys, err = h.SaveURLs(ctx, batch)
+	}
+	if err != nil {
+		return nil, err
+	}
+	logger.Log.Info("Shortened a batch of URIs", zap.Int("count", len(batch)))
+
+	// The URLs which are already shortened get the existing keys instead of their own
+	for i, u := range batch {
+		if keys[i] == u.ID() {
+			metrics.LinksCreated.Inc()
+		}
+	}
+
+	return keys, nil
+}
+
+// Check if one of the aliases of the batch is already stored or repeated in the batch. The storage doesn't tell
+// which key of the batch is taken, so the aliases are looked up once the batch has failed
+func aliasTaken(ctx context.Context, h *Handler, input []requestShortenBatchURLs) (bool, error) {
+	aliases := make(map[string]struct{}, len(input))
+	for _, url := range input {
+		if url.Alias == "" {
+			continue
+		}
+		if _, duplicate := aliases[url.Alias]; duplicate {
+			return true, nil
+		}
+		aliases[url.Alias] = struct{}{}
+
+		// The deleted and the expired URLs keep their keys too
+		if _, err := h.GetURL(ctx, url.Alias); !errors.Is(err, urlsInfra.ErrNotFoundURL) {
+			if err == nil || errors.Is(err, urlsInfra.ErrDeletedURL) || errors.Is(err, urlsInfra.ErrExpiredURL) {
+				return true, nil
+			}
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+func hasGeneratedKeys(input []requestShortenBatchURLs) bool {
+	for _, url := range input {
+		if url.Alias == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Generate the new keys for the URLs of the batch which have no alias
+func regenerateKeys(keys urls.KeyGenerator, batch []*urls.URL, input []requestShortenBatchURLs) ([]*urls.URL, error) {
+	regenerated := make([]*urls.URL, len(batch))
+	for i, u := range batch {
+		if input[i].Alias != "" {
+			regenerated[i] = u
+			continue
+		}
+
+		newURL, err := urls.NewURLWithGeneratedKey(keys, u.LongURL(), u.CorrelationID(), u.UserID())
+		if err != nil {
+			return nil, err
+		}
+		newURL.SetExpiresAt(u.ExpiresAt())
+		regenerated[i] = newURL
+	}
+
+	return regenerated, nil
+}
+
 func (h *Handler) JSONPostBatch(w http.ResponseWriter, r *http.Request) {
 	var clientInput []requestShortenBatchURLs
 	if err := json.NewDecoder(r.Body).Decode(&clientInput); err != nil {
@@ -69,31 +229,40 @@ func (h *Handler) JSONPostBatch(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var shortURLs []responseShortenBatchURLs
-	for _, url := range clientInput {
-		var shortURL string
-
-		key, err := shortenURL(url.OriginalURL, h, url.CorrelationID)
-		var errURINotUnique *urlsInfra.ErrURINotUnique
-		if errors.As(err, &errURINotUnique) {
-			shortURL = "http://" + h.Configuration.ListenAddress + "/" + errURINotUnique.ExistingKey
-		} else if errors.Is(err, urlsInfra.ErrCorIDNotUnique) {
-			http.Error(w, "The specified correlation ID is already present on the server! "+url.CorrelationID, http.StatusBadRequest)
-			return
-		} else if err != nil {
-			http.Error(w, "Bad Request", http.StatusBadRequest)
-			logger.Log.Info("Couldn't save URL sent in batch", zap.Error(err))
-			return
-		} else {
-			shortURL = "http://" + h.Configuration.ListenAddress + "/" + key
-		}
+	keys, err := shortenBatch(r.Context(), h, middlewares.UserIDFromContext(r.Context()), clientInput)
+	if errors.Is(err, errInvalidExpiry) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if errors.Is(err, urls.ErrInvalidKey) {
+		http.Error(w, "The specified alias is invalid! "+err.Error(), http.StatusBadRequest)
+		return
+	} else if errors.Is(err, urls.ErrInvalidURL) {
+		http.Error(w, "Please provide a valid original_url! "+err.Error(), http.StatusBadRequest)
+		return
+	} else if errors.Is(err, urlsInfra.ErrCorIDNotUnique) {
+		http.Error(w, "One of the specified correlation IDs is already present on the server!", http.StatusBadRequest)
+		return
+	} else if errors.Is(err, urlsInfra.ErrKeyNotUnique) {
+		http.Error(w, "One of the specified aliases is already taken!", http.StatusConflict)
+		return
+	} else if errors.Is(err, errKeyAllocation) {
+		http.Error(w, "Couldn't allocate a short key for the URL, please try again!", http.StatusServiceUnavailable)
+		return
+	} else if status := storageErrorStatus(err); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	} else if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't save URLs sent in batch", zap.Error(err))
+		return
+	}
 
-		newShortenedURL := responseShortenBatchURLs{
+	shortURLs := make([]responseShortenBatchURLs, 0, len(keys))
+	for i, url := range clientInput {
+		shortURLs = append(shortURLs, responseShortenBatchURLs{
 			CorrelationID: url.CorrelationID,
-			ShortURL:      shortURL,
-		}
-
-		shortURLs = append(shortURLs, newShortenedURL)
+			ShortURL:      h.ShortURL(keys[i]),
+		})
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -122,16 +291,34 @@ func (h *Handler) JSONPostURI(w http.ResponseWriter, r *http.Request) {
 	var shortURL string
 	responseCode := http.StatusCreated
 
-	id, err := shortenURL(clientInput.URL, h, "")
+	expiresAt, err := parseExpiry(clientInput.ExpiresIn, clientInput.ExpiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	id, err := shortenURL(r.Context(), clientInput.URL, h, "", middlewares.UserIDFromContext(r.Context()), clientInput.Alias, expiresAt)
 	var errURINotUnique *urlsInfra.ErrURINotUnique
 	if errors.As(err, &errURINotUnique) {
-		shortURL = "http://" + h.Configuration.ListenAddress + "/" + errURINotUnique.ExistingKey
+		shortURL = h.ShortURL(errURINotUnique.ExistingKey)
 		responseCode = http.StatusConflict
+	} else if errors.Is(err, urlsInfra.ErrKeyNotUnique) {
+		http.Error(w, "The specified alias is already taken! "+clientInput.Alias, http.StatusConflict)
+		return
+	} else if errors.Is(err, errKeyAllocation) {
+		http.Error(w, "Couldn't allocate a short key for the URL, please try again!", http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, urls.ErrInvalidKey) {
+		http.Error(w, "The specified alias is invalid! "+err.Error(), http.StatusBadRequest)
+		return
+	} else if status := storageErrorStatus(err); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
 	} else if err != nil {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	} else {
-		shortURL = "http://" + h.Configuration.ListenAddress + "/" + id
+		shortURL = h.ShortURL(id)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(responseCode)
@@ -159,13 +346,32 @@ func (h *Handler) PostURI(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := shortenURL(string(body), h, "")
+	expiresAt, err := parseExpiryFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	alias := r.URL.Query().Get("alias")
+	id, err := shortenURL(r.Context(), string(body), h, "", middlewares.UserIDFromContext(r.Context()), alias, expiresAt)
 	var errURINotUnique *urlsInfra.ErrURINotUnique
 	if errors.As(err, &errURINotUnique) {
 		w.WriteHeader(http.StatusConflict)
-		shortURL := "http://" + h.Configuration.ListenAddress + "/" + errURINotUnique.ExistingKey
+		shortURL := h.ShortURL(errURINotUnique.ExistingKey)
 		w.Write([]byte(shortURL)) //nolint:all
 		return
+	} else if errors.Is(err, urlsInfra.ErrKeyNotUnique) {
+		http.Error(w, "The specified alias is already taken! "+alias, http.StatusConflict)
+		return
+	} else if errors.Is(err, errKeyAllocation) {
+		http.Error(w, "Couldn't allocate a short key for the URL, please try again!", http.StatusServiceUnavailable)
+		return
+	} else if errors.Is(err, urls.ErrInvalidKey) {
+		http.Error(w, "The specified alias is invalid! "+err.Error(), http.StatusBadRequest)
+		return
+	} else if status := storageErrorStatus(err); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
 	} else if err != nil {
 		http.Error(w, "Please provide a valid URI in request body", http.StatusBadRequest)
 		logger.Log.Info("Couldn't shorten URL", zap.Error(err))
@@ -173,5 +379,5 @@ func (h *Handler) PostURI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("http://" + h.Configuration.ListenAddress + "/" + id)) //nolint:all
+	w.Write([]byte(h.ShortURL(id))) //nolint:all
 }
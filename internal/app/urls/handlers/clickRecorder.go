@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/metrics"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"go.uber.org/zap"
+)
+
+const (
+	clickQueueSize     = 1024
+	clickBatchSize     = 100
+	clickFlushInterval = time.Second
+)
+
+type clickRecorder struct {
+	repo   Repository
+	secret string
+	clicks chan urlsInfra.Click
+	stop   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Start the background worker which writes clicks in batches. Without the secret key the IPs aren't recorded at all
+func newClickRecorder(repo Repository, secret string) *clickRecorder {
+	if secret == "" {
+		logger.Log.Info("The secret key isn't configured, the unique visitors of the URLs won't be counted")
+	}
+
+	c := &clickRecorder{
+		repo:   repo,
+		secret: secret,
+		clicks: make(chan urlsInfra.Click, clickQueueSize),
+		stop:   make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.run()
+
+	return c
+}
+
+// Queue the click of the redirect. The redirect never waits for the queue, the click is dropped if it is full
+func (c *clickRecorder) record(r *http.Request, key string) {
+	click := urlsInfra.Click{
+		Key:       key,
+		Time:      time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IPHash:    c.hashIP(middlewares.ClientIP(r)),
+	}
+
+	select {
+	case c.clicks <- click:
+	default:
+		metrics.ClicksDropped.WithLabelValues("queue_full").Inc()
+	}
+}
+
+// The IP is hashed with the secret key, so the hashes can't be reversed by trying every address.
+// Behind the trusted proxy it is the IP of the client, not the one of the proxy
+func (c *clickRecorder) hashIP(ip net.IP) string {
+	if ip == nil || c.secret == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(c.secret))
+	mac.Write([]byte(ip.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Stop the worker after writing the clicks which are already queued
+func (c *clickRecorder) close() {
+	close(c.stop)
+	c.wg.Wait()
+}
+
+func (c *clickRecorder) run() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(clickFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]urlsInfra.Click, 0, clickBatchSize)
+	for {
+		select {
+		case click := <-c.clicks:
+			batch = append(batch, click)
+			if len(batch) >= clickBatchSize {
+				batch = c.flush(batch)
+			}
+		case <-ticker.C:
+			batch = c.flush(batch)
+		case <-c.stop:
+			for {
+				select {
+				case click := <-c.clicks:
+					batch = append(batch, click)
+					if len(batch) >= clickBatchSize {
+						batch = c.flush(batch)
+					}
+				default:
+					c.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *clickRecorder) flush(batch []urlsInfra.Click) []urlsInfra.Click {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := c.repo.SaveClicks(context.Background(), batch); err != nil {
+		metrics.ClicksDropped.WithLabelValues("storage_error").Add(float64(len(batch)))
+		logger.Log.Info("Couldn't record clicks", zap.Int("count", len(batch)), zap.Error(err))
+	} else {
+		metrics.ClicksRecorded.Add(float64(len(batch)))
+	}
+
+	return batch[:0]
+}
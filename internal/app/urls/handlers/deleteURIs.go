@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+)
+
+func (h *Handler) DeleteUserURIs(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		http.Error(w, "You provided invalid JSON! Please specify an array of short URL keys", http.StatusBadRequest)
+		return
+	}
+
+	err := h.deleter.enqueue(middlewares.UserIDFromContext(r.Context()), keys)
+	if errors.Is(err, errTooManyKeys) {
+		http.Error(w, "Please delete at most "+strconv.Itoa(deleteQueueSize)+" URLs at once!", http.StatusRequestEntityTooLarge)
+		return
+	} else if err != nil {
+		w.Header().Set("Retry-After", strconv.Itoa(int(deleteFlushInterval.Seconds())))
+		http.Error(w, "The URLs can't be deleted right now, please try again later!", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
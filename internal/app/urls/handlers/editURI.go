@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+	"github.com/nomardt/urlshortener-x/internal/domain/urls"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"go.uber.org/zap"
+)
+
+type requestEditURL struct {
+	OriginalURL string `json:"original_url"`
+}
+
+type requestRollbackURL struct {
+	Version int `json:"version"`
+}
+
+type responseURLVersion struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+	Version     int    `json:"version"`
+}
+
+type responseHistoryVersion struct {
+	Version     int        `json:"version"`
+	OriginalURL string     `json:"original_url"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+}
+
+// Point the short URL at the new destination. Only the owner can edit the URL, the key stays the same
+func (h *Handler) PatchURI(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	var clientInput requestEditURL
+	if err := json.NewDecoder(r.Body).Decode(&clientInput); err != nil {
+		http.Error(w, "You provided invalid JSON! Please specify original_url", http.StatusBadRequest)
+		return
+	}
+	if err := urls.ValidateURL(clientInput.OriginalURL); err != nil {
+		http.Error(w, "Please specify a valid original_url!", http.StatusBadRequest)
+		return
+	}
+
+	version, err := h.UpdateURL(r.Context(), key, middlewares.UserIDFromContext(r.Context()), clientInput.OriginalURL)
+	if !h.handleVersionError(w, key, err) {
+		return
+	}
+	logger.Log.Info("Edited the URL", zap.String("key", key), zap.Int("version", version.Version))
+
+	h.writeVersion(w, version)
+}
+
+// Point the short URL back at the destination of the earlier version. The rollback is the new version itself
+func (h *Handler) RollbackURI(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	var clientInput requestRollbackURL
+	if err := json.NewDecoder(r.Body).Decode(&clientInput); err != nil || clientInput.Version < 1 {
+		http.Error(w, "You provided invalid JSON! Please specify the version to roll back to", http.StatusBadRequest)
+		return
+	}
+
+	version, err := h.RollbackURL(r.Context(), key, middlewares.UserIDFromContext(r.Context()), clientInput.Version)
+	if !h.handleVersionError(w, key, err) {
+		return
+	}
+	logger.Log.Info("Rolled the URL back", zap.String("key", key), zap.Int("to", clientInput.Version), zap.Int("version", version.Version))
+
+	h.writeVersion(w, version)
+}
+
+// Only the owner of the URL can see where it has pointed, the oldest version goes first
+func (h *Handler) GetURIHistory(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	versions, err := h.GetURLVersions(r.Context(), key, middlewares.UserIDFromContext(r.Context()))
+	if !h.handleVersionError(w, key, err) {
+		return
+	}
+
+	serverOutput := make([]responseHistoryVersion, 0, len(versions))
+	for _, version := range versions {
+		historyVersion := responseHistoryVersion{Version: version.Version, OriginalURL: version.OriginalURL}
+		// The time isn't known for the destination the URL was created with in the file storage
+		if !version.CreatedAt.IsZero() {
+			createdAt := version.CreatedAt
+			historyVersion.CreatedAt = &createdAt
+		}
+		serverOutput = append(serverOutput, historyVersion)
+	}
+
+	writeJSON(w, serverOutput)
+}
+
+// Respond with the status of the error. Only the nil error lets the handler go on, then nothing is written
+func (h *Handler) handleVersionError(w http.ResponseWriter, key string, err error) bool {
+	var errURINotUnique *urlsInfra.ErrURINotUnique
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, urlsInfra.ErrNotFoundURL):
+		http.Error(w, "URL with the specified ID:"+key+" was not found on the server!", http.StatusNotFound)
+	case errors.Is(err, urlsInfra.ErrNotFoundVersion):
+		http.Error(w, "The URL has no such version!", http.StatusNotFound)
+	case errors.Is(err, urlsInfra.ErrNotURLOwner):
+		http.Error(w, "You can only edit your own URLs!", http.StatusForbidden)
+	case errors.Is(err, urlsInfra.ErrDeletedURL), errors.Is(err, urlsInfra.ErrExpiredURL):
+		http.Error(w, "The URL is no longer available and can't be edited!", http.StatusGone)
+	case errors.As(err, &errURINotUnique):
+		http.Error(w, "The URL has already been shortened! "+h.ShortURL(errURINotUnique.ExistingKey), http.StatusConflict)
+	default:
+		if status := storageErrorStatus(err); status != 0 {
+			http.Error(w, http.StatusText(status), status)
+			return false
+		}
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't change the URL", zap.String("key", key), zap.Error(err))
+	}
+
+	return false
+}
+
+func (h *Handler) writeVersion(w http.ResponseWriter, version *urlsInfra.URLVersion) {
+	writeJSON(w, responseURLVersion{
+		ShortURL:    h.ShortURL(version.Key),
+		OriginalURL: version.OriginalURL,
+		Version:     version.Version,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, serverOutput any) {
+	jsonResp, err := json.MarshalIndent(serverOutput, "", "	")
+	if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't create JSON", zap.String("error", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(jsonResp); err != nil {
+		logger.Log.Info("Couldn't send the response with the URL versions", zap.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nomardt/urlshortener-x/internal/domain/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+)
+
+// Get the status code for the errors caused by a slow, unreachable or full storage, 0 is returned for any other error
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, urlsInfra.ErrStorageTimeout):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, urlsInfra.ErrStorageUnavailable), errors.Is(err, urls.ErrKeySpaceExhausted),
+		errors.Is(err, errKeyAllocation):
+		return http.StatusServiceUnavailable
+	default:
+		return 0
+	}
+}
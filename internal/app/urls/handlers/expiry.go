@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The longest expires_in, 100 years. The longer ones would overflow the duration
+const maxExpiresIn = 100 * 365 * 24 * 60 * 60
+
+var errInvalidExpiry = errors.New("please specify either a positive expires_in of at most 100 years in seconds or a future expires_at in RFC 3339 format")
+
+// Get the moment the shortened URL expires at. The zero time is returned if the client set no expiry
+func parseExpiry(expiresIn int64, expiresAt *time.Time) (time.Time, error) {
+	switch {
+	case expiresIn != 0 && expiresAt != nil:
+		return time.Time{}, errInvalidExpiry
+	case expiresIn < 0, expiresIn > maxExpiresIn:
+		return time.Time{}, errInvalidExpiry
+	case expiresIn > 0:
+		return time.Now().Add(time.Duration(expiresIn) * time.Second), nil
+	case expiresAt != nil:
+		if !expiresAt.After(time.Now()) {
+			return time.Time{}, errInvalidExpiry
+		}
+		return *expiresAt, nil
+	default:
+		return time.Time{}, nil
+	}
+}
+
+// The same as parseExpiry but for the expires_in and expires_at query parameters
+func parseExpiryFromQuery(query url.Values) (time.Time, error) {
+	var expiresIn int64
+	if rawExpiresIn := query.Get("expires_in"); rawExpiresIn != "" {
+		var err error
+		if expiresIn, err = strconv.ParseInt(rawExpiresIn, 10, 64); err != nil {
+			return time.Time{}, errInvalidExpiry
+		}
+	}
+
+	var expiresAt *time.Time
+	if rawExpiresAt := query.Get("expires_at"); rawExpiresAt != "" {
+		parsed, err := time.Parse(time.RFC3339, rawExpiresAt)
+		if err != nil {
+			return time.Time{}, errInvalidExpiry
+		}
+		expiresAt = &parsed
+	}
+
+	return parseExpiry(expiresIn, expiresAt)
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"go.uber.org/zap"
+)
+
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeCSV    = "text/csv"
+)
+
+// The columns of the CSV export, the owner is exported for the admins only
+var exportColumns = []string{"key", "short_url", "original_url", "correlation_id", "expires_at"}
+
+type exportedURL struct {
+	Key           string     `json:"key"`
+	ShortURL      string     `json:"short_url"`
+	OriginalURL   string     `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	UserID        string     `json:"user_id,omitempty"`
+}
+
+func (u exportedURL) csvRecord(withOwner bool) []string {
+	var expiresAt string
+	if u.ExpiresAt != nil {
+		expiresAt = u.ExpiresAt.Format(time.RFC3339)
+	}
+
+	record := []string{u.Key, u.ShortURL, u.OriginalURL, u.CorrelationID, expiresAt}
+	if withOwner {
+		record = append(record, u.UserID)
+	}
+	return record
+}
+
+// Writes the exported URLs in one of the formats
+type exportWriter interface {
+	write(url exportedURL) error
+	flush() error
+}
+
+type ndjsonExportWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonExportWriter) write(url exportedURL) error {
+	return w.encoder.Encode(url)
+}
+
+func (w *ndjsonExportWriter) flush() error {
+	return nil
+}
+
+type csvExportWriter struct {
+	writer    *csv.Writer
+	withOwner bool
+}
+
+func (w *csvExportWriter) write(url exportedURL) error {
+	return w.writer.Write(url.csvRecord(w.withOwner))
+}
+
+func (w *csvExportWriter) flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// Pick the format of the export by the Accept header, NDJSON is the default one.
+// The empty string is returned if the client accepts neither of the formats
+func exportFormat(accept string) string {
+	if accept == "" {
+		return contentTypeNDJSON
+	}
+
+	for _, mediaType := range strings.Split(accept, ",") {
+		mediaType, _, _ = strings.Cut(mediaType, ";")
+		switch strings.ToLower(strings.TrimSpace(mediaType)) {
+		case contentTypeCSV:
+			return contentTypeCSV
+		case contentTypeNDJSON, "application/ndjson", "*/*":
+			return contentTypeNDJSON
+		}
+	}
+
+	return ""
+}
+
+// Stream the URLs of the user, or of everyone if the user is an admin. The URLs are written batch by batch,
+// so the response starts before the URLs are read completely. The deleted and the expired URLs aren't exported
+func (h *Handler) ExportURIs(w http.ResponseWriter, r *http.Request) {
+	format := exportFormat(r.Header.Get("Accept"))
+	if format == "" {
+		http.Error(w, "Please accept either \"application/x-ndjson\" or \"text/csv\"!", http.StatusNotAcceptable)
+		return
+	}
+
+	userID := middlewares.UserIDFromContext(r.Context())
+	isAdmin := middlewares.IsKnownUser(r.Context()) && h.IsAdmin(userID)
+
+	var writer exportWriter
+	if format == contentTypeCSV {
+		writer = &csvExportWriter{writer: csv.NewWriter(w), withOwner: isAdmin}
+	} else {
+		writer = &ndjsonExportWriter{encoder: json.NewEncoder(w)}
+	}
+
+	started := false
+	exportBatch := func(urls []urlsInfra.StoredURL) error {
+		if !started {
+			started = true
+			if err := startExport(w, format, isAdmin); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		for _, url := range urls {
+			if url.IsDeleted || (url.ExpiresAt != nil && !now.Before(*url.ExpiresAt)) {
+				continue
+			}
+
+			exported := exportedURL{
+				Key:           url.Key,
+				ShortURL:      h.ShortURL(url.Key),
+				OriginalURL:   url.OriginalURL,
+				CorrelationID: url.CorrelationID,
+				ExpiresAt:     url.ExpiresAt,
+			}
+			if isAdmin {
+				exported.UserID = url.UserID
+			}
+			if err := writer.write(exported); err != nil {
+				return err
+			}
+		}
+		if err := writer.flush(); err != nil {
+			return err
+		}
+
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		return nil
+	}
+
+	var err error
+	if isAdmin {
+		err = h.ScanStoredURLs(r.Context(), "", 0, exportBatch)
+	} else {
+		err = h.ScanUserURLs(r.Context(), userID, "", 0, exportBatch)
+	}
+
+	// Once the response has started the status can't be changed, the client gets the truncated export
+	if err != nil && started {
+		logger.Log.Info("The export was interrupted", zap.Error(err))
+		return
+	}
+	if status := storageErrorStatus(err); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	} else if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't export the URLs", zap.Error(err))
+		return
+	}
+
+	// The user has no URLs, the export is empty
+	if !started {
+		if err := startExport(w, format, isAdmin); err != nil {
+			logger.Log.Info("Couldn't send the export", zap.Error(err))
+		}
+	}
+}
+
+// Write the headers of the export and the header row of the CSV
+func startExport(w http.ResponseWriter, format string, withOwner bool) error {
+	extension := "ndjson"
+	if format == contentTypeCSV {
+		extension = "csv"
+	}
+	w.Header().Set("Content-Type", format)
+	w.Header().Set("Content-Disposition", `attachment; filename="links.`+extension+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	if format != contentTypeCSV {
+		return nil
+	}
+
+	columns := exportColumns
+	if withOwner {
+		columns = append(columns[:len(columns):len(columns)], "user_id")
+	}
+	_, err := io.WriteString(w, strings.Join(columns, ",")+"\n")
+	return err
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"go.uber.org/zap"
+)
+
+type responseInternalStats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
+// The totals of the service for the capacity dashboards
+func (h *Handler) GetInternalStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.GetServiceStats(r.Context())
+	if status := storageErrorStatus(err); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	} else if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't count the URLs and the users", zap.Error(err))
+		return
+	}
+
+	jsonResp, err := json.MarshalIndent(responseInternalStats{URLs: stats.URLs, Users: stats.Users}, "", "	")
+	if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't create JSON", zap.String("error", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(jsonResp)
+	if err != nil {
+		logger.Log.Info("Couldn't send the response with the internal statistics", zap.String("error", err.Error()))
+		return
+	}
+}
@@ -6,17 +6,32 @@ import (
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/metrics"
 	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"go.uber.org/zap"
 )
 
 func (h *Handler) GetURI(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 
-	if url, err := h.GetURL(&id); !errors.Is(err, urlsInfra.ErrNotFoundURL) {
+	if url, err := h.GetURL(r.Context(), id); errors.Is(err, urlsInfra.ErrDeletedURL) {
+		http.Error(w, "URL with the specified ID:"+id+" has been deleted!", http.StatusGone)
+	} else if errors.Is(err, urlsInfra.ErrExpiredURL) {
+		http.Error(w, "URL with the specified ID:"+id+" has expired!", http.StatusGone)
+	} else if errors.Is(err, urlsInfra.ErrNotFoundURL) {
+		metrics.LinksNotFound.Inc()
+		http.Error(w, "URL with the specified ID:"+id+" was not found on the server!", http.StatusBadRequest)
+	} else if status := storageErrorStatus(err); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+	} else if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't get the URL", zap.String("id", id), zap.Error(err))
+	} else {
+		h.clicks.record(r, id)
+
 		w.Header().Set("Location", url)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusTemporaryRedirect)
-	} else {
-		http.Error(w, "URL with the specified ID:"+id+" was not found on the server!", http.StatusBadRequest)
 	}
 }
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"go.uber.org/zap"
+)
+
+type responseURLStats struct {
+	ShortURL       string               `json:"short_url"`
+	TotalClicks    int                  `json:"total_clicks"`
+	UniqueVisitors int                  `json:"unique_visitors"`
+	TopReferrers   []responseStatsCount `json:"top_referrers"`
+	TopUserAgents  []responseStatsCount `json:"top_user_agents"`
+	Daily          []responseDailyStats `json:"daily"`
+}
+
+type responseStatsCount struct {
+	Value  string `json:"value"`
+	Clicks int    `json:"clicks"`
+}
+
+type responseDailyStats struct {
+	Date   string `json:"date"`
+	Clicks int    `json:"clicks"`
+}
+
+func newResponseStatsCounts(counts []urlsInfra.StatsCount) []responseStatsCount {
+	response := make([]responseStatsCount, 0, len(counts))
+	for _, count := range counts {
+		response = append(response, responseStatsCount{Value: count.Value, Clicks: count.Count})
+	}
+	return response
+}
+
+// Only the owner of the URL can see its statistics
+func (h *Handler) GetURIStats(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+
+	stats, err := h.GetURLStats(r.Context(), key, middlewares.UserIDFromContext(r.Context()))
+	if errors.Is(err, urlsInfra.ErrNotFoundURL) {
+		http.Error(w, "URL with the specified ID:"+key+" was not found on the server!", http.StatusNotFound)
+		return
+	} else if errors.Is(err, urlsInfra.ErrNotURLOwner) {
+		http.Error(w, "You can only see the statistics of your own URLs!", http.StatusForbidden)
+		return
+	} else if status := storageErrorStatus(err); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	} else if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't get the URL statistics", zap.String("key", key), zap.Error(err))
+		return
+	}
+
+	serverOutput := responseURLStats{
+		ShortURL:       h.ShortURL(stats.Key),
+		TotalClicks:    stats.TotalClicks,
+		UniqueVisitors: stats.UniqueVisitors,
+		TopReferrers:   newResponseStatsCounts(stats.TopReferrers),
+		TopUserAgents:  newResponseStatsCounts(stats.TopUserAgents),
+		Daily:          make([]responseDailyStats, 0, len(stats.Daily)),
+	}
+	for _, daily := range stats.Daily {
+		serverOutput.Daily = append(serverOutput.Daily, responseDailyStats{
+			Date:   daily.Date.Format("2006-01-02"),
+			Clicks: daily.Count,
+		})
+	}
+
+	jsonResp, err := json.MarshalIndent(serverOutput, "", "	")
+	if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't create JSON", zap.String("error", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(jsonResp)
+	if err != nil {
+		logger.Log.Info("Couldn't send the response with the URL statistics", zap.String("error", err.Error()))
+		return
+	}
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"go.uber.org/zap"
+)
+
+type responseUserURL struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+}
+
+func (h *Handler) GetUserURIs(w http.ResponseWriter, r *http.Request) {
+	userURLs, err := h.GetURLsByUser(r.Context(), middlewares.UserIDFromContext(r.Context()))
+	if status := storageErrorStatus(err); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	} else if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't get the user's URLs", zap.Error(err))
+		return
+	}
+
+	if len(userURLs) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	serverOutput := make([]responseUserURL, 0, len(userURLs))
+	for _, u := range userURLs {
+		serverOutput = append(serverOutput, responseUserURL{
+			ShortURL:    h.ShortURL(u.ID()),
+			OriginalURL: u.LongURL(),
+		})
+	}
+
+	jsonResp, err := json.MarshalIndent(serverOutput, "", "	")
+	if err != nil {
+		http.Error(w, "Something went wrong...", http.StatusInternalServerError)
+		logger.Log.Info("Couldn't create JSON", zap.String("error", err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(jsonResp)
+	if err != nil {
+		logger.Log.Info("Couldn't send the response with the user's URLs", zap.String("error", err.Error()))
+		return
+	}
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+	"github.com/nomardt/urlshortener-x/internal/domain/urls"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	pb "github.com/nomardt/urlshortener-x/pkg/shortenerpb"
+	"go.uber.org/zap"
+)
+
+// The gRPC API shares the validation and the conflict semantics with the HTTP handlers
+type GRPCServer struct {
+	pb.UnimplementedShortenerServer
+	h *Handler
+}
+
+func NewGRPCServer(h *Handler) *GRPCServer {
+	return &GRPCServer{h: h}
+}
+
+func (s *GRPCServer) Shorten(ctx context.Context, req *pb.ShortenRequest) (*pb.ShortenResponse, error) {
+	expiresAt, err := parseExpiry(req.GetExpiresIn(), timestampOrNil(req.GetExpiresAt()))
+	if err != nil {
+		return nil, s.grpcError(err)
+	}
+
+	key, err := shortenURL(ctx, req.GetUrl(), s.h, "", middlewares.UserIDFromContext(ctx), req.GetAlias(), expiresAt)
+	if err != nil {
+		return nil, s.grpcError(err)
+	}
+
+	return &pb.ShortenResponse{ShortUrl: s.h.ShortURL(key)}, nil
+}
+
+func (s *GRPCServer) ShortenBatch(ctx context.Context, req *pb.ShortenBatchRequest) (*pb.ShortenBatchResponse, error) {
+	input := make([]requestShortenBatchURLs, 0, len(req.GetUrls()))
+	for _, url := range req.GetUrls() {
+		input = append(input, requestShortenBatchURLs{
+			CorrelationID: url.GetCorrelationId(),
+			OriginalURL:   url.GetOriginalUrl(),
+			Alias:         url.GetAlias(),
+			ExpiresIn:     url.GetExpiresIn(),
+			ExpiresAt:     timestampOrNil(url.GetExpiresAt()),
+		})
+	}
+
+	keys, err := shortenBatch(ctx, s.h, middlewares.UserIDFromContext(ctx), input)
+	if err != nil {
+		return nil, s.grpcError(err)
+	}
+
+	resp := &pb.ShortenBatchResponse{Urls: make([]*pb.ShortenBatchResponse_URL, 0, len(keys))}
+	for i, url := range input {
+		resp.Urls = append(resp.Urls, &pb.ShortenBatchResponse_URL{
+			CorrelationId: url.CorrelationID,
+			ShortUrl:      s.h.ShortURL(keys[i]),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *GRPCServer) Resolve(ctx context.Context, req *pb.ResolveRequest) (*pb.ResolveResponse, error) {
+	url, err := s.h.GetURL(ctx, req.GetKey())
+	if err != nil {
+		return nil, s.grpcError(err)
+	}
+
+	return &pb.ResolveResponse{OriginalUrl: url}, nil
+}
+
+func (s *GRPCServer) ListUserURLs(ctx context.Context, req *pb.ListUserURLsRequest) (*pb.ListUserURLsResponse, error) {
+	userURLs, err := s.h.GetURLsByUser(ctx, middlewares.UserIDFromContext(ctx))
+	if err != nil {
+		return nil, s.grpcError(err)
+	}
+
+	resp := &pb.ListUserURLsResponse{Urls: make([]*pb.ListUserURLsResponse_URL, 0, len(userURLs))}
+	for _, u := range userURLs {
+		resp.Urls = append(resp.Urls, &pb.ListUserURLsResponse_URL{
+			ShortUrl:    s.h.ShortURL(u.ID()),
+			OriginalUrl: u.LongURL(),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *GRPCServer) DeleteUserURLs(ctx context.Context, req *pb.DeleteUserURLsRequest) (*pb.DeleteUserURLsResponse, error) {
+	if err := s.h.deleter.enqueue(middlewares.UserIDFromContext(ctx), req.GetKeys()); err != nil {
+		return nil, s.grpcError(err)
+	}
+
+	return &pb.DeleteUserURLsResponse{}, nil
+}
+
+// Map the errors of the shortener to the gRPC status codes the same way the HTTP handlers map them to the status codes
+func (s *GRPCServer) grpcError(err error) error {
+	var errURINotUnique *urlsInfra.ErrURINotUnique
+	switch {
+	case errors.As(err, &errURINotUnique):
+		return status.Error(codes.AlreadyExists, "the URL is already shortened: "+s.h.ShortURL(errURINotUnique.ExistingKey))
+	case errors.Is(err, urlsInfra.ErrCorIDNotUnique), errors.Is(err, urlsInfra.ErrKeyNotUnique):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, errInvalidExpiry), errors.Is(err, urls.ErrInvalidURL), errors.Is(err, urls.ErrInvalidKey), errors.Is(err, errTooManyKeys):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, urlsInfra.ErrNotFoundURL), errors.Is(err, urlsInfra.ErrDeletedURL), errors.Is(err, urlsInfra.ErrExpiredURL):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, urlsInfra.ErrStorageTimeout):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, urlsInfra.ErrStorageUnavailable), errors.Is(err, urls.ErrKeySpaceExhausted), errors.Is(err, errKeyAllocation),
+		errors.Is(err, errDeleteQueueFull), errors.Is(err, errDeleterClosed):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		logger.Log.Info("Couldn't handle the gRPC request", zap.Error(err))
+		return status.Error(codes.Internal, "something went wrong")
+	}
+}
+
+func timestampOrNil(ts *timestamppb.Timestamp) *time.Time {
+	if ts == nil {
+		return nil
+	}
+
+	t := ts.AsTime()
+	return &t
+}
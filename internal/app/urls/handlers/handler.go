@@ -5,22 +5,112 @@ import (
 
 	conf "github.com/nomardt/urlshortener-x/cmd/config"
 	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/ratelimit"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"go.uber.org/zap"
 )
 
 type Repository interface {
-	SaveURL(*urlsDomain.URL) error
-	GetURL(*string) (string, error)
+	StatsRepository
+	ExportRepository
+	VersionRepository
+	SaveURL(ctx context.Context, url *urlsDomain.URL) error
+	SaveURLs(ctx context.Context, urls []*urlsDomain.URL) ([]string, error)
+	GetURL(ctx context.Context, key string) (string, error)
+	GetURLsByUser(ctx context.Context, userID string) ([]*urlsDomain.URL, error)
+	DeleteURLs(ctx context.Context, requests []urlsInfra.DeleteRequest) error
+	DeleteExpiredURLs(ctx context.Context) (int, error)
+	SaveClicks(ctx context.Context, clicks []urlsInfra.Click) error
 	Ping(ctx context.Context) error
 }
 
+// The click statistics of the shortened URLs
+type StatsRepository interface {
+	GetURLStats(ctx context.Context, key string, userID string) (*urlsInfra.URLStats, error)
+	GetServiceStats(ctx context.Context) (*urlsInfra.ServiceStats, error)
+	// Every stored key is counted, including the ones of the deleted and the expired URLs
+	CountKeys(ctx context.Context) (int, error)
+}
+
+// The stored URLs are read in batches, so that the export doesn't keep all of them in memory
+type ExportRepository interface {
+	ScanStoredURLs(ctx context.Context, after string, batchSize int, fn func([]urlsInfra.StoredURL) error) error
+	ScanUserURLs(ctx context.Context, userID string, after string, batchSize int, fn func([]urlsInfra.StoredURL) error) error
+}
+
+// The destinations of the URLs are changed only by their owners, every change is kept in the history
+type VersionRepository interface {
+	UpdateURL(ctx context.Context, key string, userID string, originalURL string) (*urlsInfra.URLVersion, error)
+	RollbackURL(ctx context.Context, key string, userID string, version int) (*urlsInfra.URLVersion, error)
+	GetURLVersions(ctx context.Context, key string, userID string) ([]urlsInfra.URLVersion, error)
+}
+
 type Handler struct {
 	Repository
 	conf.Configuration
+	deleter *urlDeleter
+	clicks  *clickRecorder
+	keys    urlsDomain.KeyGenerator
+	limits  ratelimit.Store
+}
+
+type Option func(h *Handler)
+
+// Generate the keys with the specified generator instead of the new one made from the configuration,
+// e.g. the counter which continues from the stored keys
+func WithKeyGenerator(keys urlsDomain.KeyGenerator) Option {
+	return func(h *Handler) {
+		h.keys = keys
+	}
+}
+
+// Share the rate limits of the HTTP routes with the gRPC server
+func WithRateLimitStore(store ratelimit.Store) Option {
+	return func(h *Handler) {
+		h.limits = store
+	}
 }
 
-func NewHandler(repo Repository, config conf.Configuration) *Handler {
-	return &Handler{
+// The IP hashes have to be the same after a restart, so the generated secret key isn't used for them
+func clickSecret(config conf.Configuration) string {
+	if config.SecretKeyGenerated {
+		return ""
+	}
+
+	return config.SecretKey
+}
+
+func NewHandler(repo Repository, config conf.Configuration, opts ...Option) *Handler {
+	h := &Handler{
 		Repository:    repo,
 		Configuration: config,
+		deleter:       newURLDeleter(repo),
+		clicks:        newClickRecorder(repo, clickSecret(config)),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	if h.keys == nil {
+		keys, err := urlsDomain.NewKeyGenerator(config.KeyGenerator, config.KeyLength, config.KeyAlphabet)
+		if err != nil {
+			logger.Log.Info("The key generator is misconfigured, the random keys are generated", zap.Error(err))
+			keys, _ = urlsDomain.NewKeyGenerator(urlsDomain.KeyGeneratorRandom, 0, "")
+		}
+		h.keys = keys
 	}
+
+	return h
+}
+
+// The store of the rate limits, nil if the limiter is disabled
+func (h *Handler) RateLimitStore() ratelimit.Store {
+	return h.limits
+}
+
+// Stop the background workers after they write everything they have already received
+func (h *Handler) Close() {
+	h.deleter.close()
+	h.clicks.close()
 }
@@ -1,6 +1,7 @@
 package handlers_test
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -11,15 +12,16 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	conf "github.com/nomardt/urlshortener-x/cmd/config"
 	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
 	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func newMockConfig(listenAddress string, path string) conf.Configuration {
+func newMockConfig(listenAddress string, baseURL string) conf.Configuration {
 	return conf.Configuration{
 		ListenAddress: listenAddress,
-		Path:          path,
+		BaseURL:       baseURL,
 	}
 }
 
@@ -83,6 +85,42 @@ func Test_PostURI(t *testing.T) {
 			expectedCode: http.StatusBadRequest,
 			args:         args{"/", "text/plain", "gopher://example.com"},
 		},
+		{
+			name:         "POST with alias, all ok",
+			method:       http.MethodPost,
+			expectedCode: http.StatusCreated,
+			args:         args{"/?alias=my-link", "text/plain", "https://example.org"},
+		},
+		{
+			name:         "POST with alias, alias taken",
+			method:       http.MethodPost,
+			expectedCode: http.StatusConflict,
+			args:         args{"/?alias=my-link", "text/plain", "https://example.net"},
+		},
+		{
+			name:         "POST with alias, invalid alias",
+			method:       http.MethodPost,
+			expectedCode: http.StatusBadRequest,
+			args:         args{"/?alias=my%20link", "text/plain", "https://example.edu"},
+		},
+		{
+			name:         "POST with expiry, all ok",
+			method:       http.MethodPost,
+			expectedCode: http.StatusCreated,
+			args:         args{"/?expires_in=60", "text/plain", "https://example.io"},
+		},
+		{
+			name:         "POST with expiry, negative expires_in",
+			method:       http.MethodPost,
+			expectedCode: http.StatusBadRequest,
+			args:         args{"/?expires_in=-60", "text/plain", "https://example.dev"},
+		},
+		{
+			name:         "POST with expiry, expires_in overflows the duration",
+			method:       http.MethodPost,
+			expectedCode: http.StatusBadRequest,
+			args:         args{"/?expires_in=9223372036854775807", "text/plain", "https://example.dev"},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -94,3 +132,162 @@ func Test_PostURI(t *testing.T) {
 		})
 	}
 }
+
+func Test_PostURIWithBaseURL(t *testing.T) {
+	router := chi.NewRouter()
+
+	config := newMockConfig("127.0.0.1:8080", "https://short.example/links")
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	t.Run("Short URL uses the base URL", func(t *testing.T) {
+		resp, body := testPostRequest(t, ts, http.MethodPost, "/links/?alias=campaign", "text/plain", "https://example.com")
+
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "https://short.example/links/campaign", body)
+	})
+
+	t.Run("Routes are mounted under the base path", func(t *testing.T) {
+		resp, _ := testGetRequest(t, ts, http.MethodGet, "/links/campaign")
+		assert.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+		resp, _ = testGetRequest(t, ts, http.MethodGet, "/campaign")
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func Test_JSONPostBatch(t *testing.T) {
+	router := chi.NewRouter()
+
+	config := newMockConfig("127.0.0.1:8080", "")
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{
+			name:         "All ok",
+			body:         `[{"correlation_id": "1", "original_url": "https://example.com"}, {"correlation_id": "2", "original_url": "https://example.org", "alias": "org"}]`,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "Invalid URL in batch",
+			body:         `[{"correlation_id": "3", "original_url": "https://example.net"}, {"correlation_id": "4", "original_url": "gopher://example.net"}]`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "Alias taken",
+			body:         `[{"correlation_id": "5", "original_url": "https://example.edu"}, {"correlation_id": "6", "original_url": "https://example.io", "alias": "org"}]`,
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "Nothing is saved from a failed batch",
+			body:         `[{"correlation_id": "5", "original_url": "https://example.edu"}]`,
+			expectedCode: http.StatusCreated,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, _ := testPostRequest(t, ts, http.MethodPost, "/api/shorten/batch", "application/json", tc.body)
+			assert.Equal(t, tc.expectedCode, resp.StatusCode)
+		})
+	}
+}
+
+func Test_KeyCollisions(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "http://localhost:8080")
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	// The first generated key is already taken as an alias
+	keys, err := urlsDomain.NewKeyGenerator(urlsDomain.KeyGeneratorSequential, 1, "ab")
+	require.NoError(t, err)
+	taken, err := urlsDomain.NewURL("https://example.com/alias", "a", "alias", "")
+	require.NoError(t, err)
+	require.NoError(t, urlsRepo.SaveURL(context.Background(), taken))
+
+	router := chi.NewRouter()
+	urls.Setup(router, urlsRepo, config, urls.WithKeyGenerator(keys))
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Test case: The next key is tried
+	resp, body := testPostRequest(t, ts, http.MethodPost, "/", "text/plain", "https://example.com/1")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, "http://localhost:8080/b", body)
+
+	// Test case: Every key is taken
+	resp, _ = testPostRequest(t, ts, http.MethodPost, "/", "text/plain", "https://example.com/2")
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+// The generator which always returns the same key, it counts how many keys it was asked for
+type constantKeyGenerator struct {
+	key   string
+	calls int
+}
+
+func (g *constantKeyGenerator) NextKey() (string, error) {
+	g.calls++
+	return g.key, nil
+}
+
+func (g *constantKeyGenerator) KeySpace() float64 {
+	return 1
+}
+
+func Test_KeyAllocation(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "http://localhost:8080")
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	taken, err := urlsDomain.NewURL("https://example.com/taken", "taken", "taken", "")
+	require.NoError(t, err)
+	require.NoError(t, urlsRepo.SaveURL(context.Background(), taken))
+
+	keys := &constantKeyGenerator{key: "taken"}
+	router := chi.NewRouter()
+	urls.Setup(router, urlsRepo, config, urls.WithKeyGenerator(keys))
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	t.Run("The generated keys run out", func(t *testing.T) {
+		keys.calls = 0
+		resp, body := testPostRequest(t, ts, http.MethodPost, "/", "text/plain", "https://example.com/1")
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.NotContains(t, body, "alias")
+		assert.Equal(t, 5, keys.calls)
+
+		resp, _ = testPostRequest(t, ts, http.MethodPost, "/api/shorten", "application/json", `{"url":"https://example.com/2"}`)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	})
+
+	t.Run("The generated keys of the batch run out", func(t *testing.T) {
+		keys.calls = 0
+		resp, _ := testPostRequest(t, ts, http.MethodPost, "/api/shorten/batch", "application/json",
+			`[{"correlation_id": "1", "original_url": "https://example.com/3"}]`)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, 5, keys.calls)
+	})
+
+	t.Run("The taken alias of the batch isn't retried", func(t *testing.T) {
+		keys.key = "free"
+		keys.calls = 0
+		resp, _ := testPostRequest(t, ts, http.MethodPost, "/api/shorten/batch", "application/json",
+			`[{"correlation_id": "2", "original_url": "https://example.com/4"}, {"correlation_id": "3", "original_url": "https://example.com/5", "alias": "taken"}]`)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		assert.Equal(t, 1, keys.calls)
+	})
+}
@@ -0,0 +1,137 @@
+package handlers_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DeleteUserURIs(t *testing.T) {
+	router := chi.NewRouter()
+
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.SecretKey = "secret"
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	owner := &http.Client{
+		Transport: ts.Client().Transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	do := func(method, path, contentType, body string) (int, string) {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+
+		resp, err := owner.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(respBody)
+	}
+	keyOf := func(shortURL string) string {
+		return shortURL[strings.LastIndex(shortURL, "/")+1:]
+	}
+
+	code, shortURL := do(http.MethodPost, "/", "text/plain", "https://example.com")
+	require.Equal(t, http.StatusCreated, code)
+	ownedKey := keyOf(shortURL)
+
+	// This URL is shortened by a client without the owner's cookie
+	resp, shortURL := testPostRequest(t, ts, http.MethodPost, "/", "text/plain", "https://example.org")
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	strangerKey := keyOf(shortURL)
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		code, _ := do(http.MethodDelete, "/api/user/urls", "application/json", "not json")
+		assert.Equal(t, http.StatusBadRequest, code)
+	})
+
+	t.Run("Owned URL is deleted", func(t *testing.T) {
+		code, _ := do(http.MethodDelete, "/api/user/urls", "application/json", `["`+ownedKey+`", "`+strangerKey+`"]`)
+		assert.Equal(t, http.StatusAccepted, code)
+
+		assert.Eventually(t, func() bool {
+			code, _ := do(http.MethodGet, "/"+ownedKey, "", "")
+			return code == http.StatusGone
+		}, 3*time.Second, 100*time.Millisecond)
+	})
+
+	t.Run("Someone else's URL is not deleted", func(t *testing.T) {
+		code, _ := do(http.MethodGet, "/"+strangerKey, "", "")
+		assert.Equal(t, http.StatusTemporaryRedirect, code)
+	})
+}
+
+func Test_DeleteUserURIsOnClose(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.SecretKey = "secret"
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+	url, err := urlsDomain.NewURL("https://example.com", "owned", "owned", "alice")
+	require.NoError(t, err)
+	require.NoError(t, urlsRepo.SaveURL(context.Background(), url))
+
+	router := chi.NewRouter()
+	handler := urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	do := func(body string) int {
+		req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/user/urls", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(authCookie("alice", config.SecretKey))
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("Too many keys", func(t *testing.T) {
+		keys := make([]string, 10001)
+		for i := range keys {
+			keys[i] = "key"
+		}
+		assert.Equal(t, http.StatusRequestEntityTooLarge, do(`["`+strings.Join(keys, `","`)+`"]`))
+	})
+
+	t.Run("The accepted deletion is written before the handler closes", func(t *testing.T) {
+		require.Equal(t, http.StatusAccepted, do(`["owned"]`))
+		handler.Close()
+
+		_, err := urlsRepo.GetURL(context.Background(), "owned")
+		assert.ErrorIs(t, err, urlsInfra.ErrDeletedURL)
+	})
+
+	t.Run("Nothing is accepted after the handler closes", func(t *testing.T) {
+		assert.Equal(t, http.StatusServiceUnavailable, do(`["owned"]`))
+	})
+}
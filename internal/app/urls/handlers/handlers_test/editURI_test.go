@@ -0,0 +1,214 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type urlVersion struct {
+	ShortURL    string  `json:"short_url"`
+	OriginalURL string  `json:"original_url"`
+	Version     int     `json:"version"`
+	CreatedAt   *string `json:"created_at"`
+}
+
+func Test_EditURI(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "http://localhost:8080")
+	config.SecretKey = "secret"
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+	for _, u := range []struct{ longURL, key, userID string }{
+		{"https://example.com/v1", "edit", "alice"},
+		{"https://example.com/taken", "taken", "bob"},
+		{"https://example.com/gone", "gone", "alice"},
+	} {
+		url, err := urlsDomain.NewURL(u.longURL, u.key, u.key, u.userID)
+		require.NoError(t, err)
+		require.NoError(t, urlsRepo.SaveURL(context.Background(), url))
+	}
+	require.NoError(t, urlsRepo.DeleteURLs(context.Background(), []urlsInfra.DeleteRequest{{UserID: "alice", Key: "gone"}}))
+
+	router := chi.NewRouter()
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	do := func(userID string, method string, path string, body string) (int, string) {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.AddCookie(authCookie(userID, config.SecretKey))
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(respBody)
+	}
+
+	tests := []struct {
+		name        string
+		userID      string
+		method      string
+		path        string
+		body        string
+		wantStatus  int
+		wantVersion int
+		wantURL     string
+	}{
+		{
+			name:        "The owner edits the URL",
+			userID:      "alice",
+			method:      http.MethodPatch,
+			path:        "/api/urls/edit",
+			body:        `{"original_url":"https://example.com/v2"}`,
+			wantStatus:  http.StatusOK,
+			wantVersion: 2,
+			wantURL:     "https://example.com/v2",
+		},
+		{
+			name:        "The same destination doesn't add a version",
+			userID:      "alice",
+			method:      http.MethodPatch,
+			path:        "/api/urls/edit",
+			body:        `{"original_url":"https://example.com/v2"}`,
+			wantStatus:  http.StatusOK,
+			wantVersion: 2,
+			wantURL:     "https://example.com/v2",
+		},
+		{
+			name:        "The owner rolls the URL back",
+			userID:      "alice",
+			method:      http.MethodPost,
+			path:        "/api/urls/edit/rollback",
+			body:        `{"version":1}`,
+			wantStatus:  http.StatusOK,
+			wantVersion: 3,
+			wantURL:     "https://example.com/v1",
+		},
+		{
+			name:       "Somebody else's URL",
+			userID:     "bob",
+			method:     http.MethodPatch,
+			path:       "/api/urls/edit",
+			body:       `{"original_url":"https://example.com/bob"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "The destination is shortened by another URL",
+			userID:     "alice",
+			method:     http.MethodPatch,
+			path:       "/api/urls/edit",
+			body:       `{"original_url":"https://example.com/taken"}`,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "Invalid destination",
+			userID:     "alice",
+			method:     http.MethodPatch,
+			path:       "/api/urls/edit",
+			body:       `{"original_url":"example"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Deleted URL",
+			userID:     "alice",
+			method:     http.MethodPatch,
+			path:       "/api/urls/gone",
+			body:       `{"original_url":"https://example.com/back"}`,
+			wantStatus: http.StatusGone,
+		},
+		{
+			name:       "Unknown URL",
+			userID:     "alice",
+			method:     http.MethodPatch,
+			path:       "/api/urls/unknown",
+			body:       `{"original_url":"https://example.com/unknown"}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Unknown version",
+			userID:     "alice",
+			method:     http.MethodPost,
+			path:       "/api/urls/edit/rollback",
+			body:       `{"version":10}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "No version to roll back to",
+			userID:     "alice",
+			method:     http.MethodPost,
+			path:       "/api/urls/edit/rollback",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := do(tt.userID, tt.method, tt.path, tt.body)
+			require.Equal(t, tt.wantStatus, status, body)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var version urlVersion
+			require.NoError(t, json.Unmarshal([]byte(body), &version))
+			assert.Equal(t, "http://localhost:8080/edit", version.ShortURL)
+			assert.Equal(t, tt.wantVersion, version.Version)
+			assert.Equal(t, tt.wantURL, version.OriginalURL)
+
+			originalURL, err := urlsRepo.GetURL(context.Background(), "edit")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantURL, originalURL)
+		})
+	}
+
+	t.Run("The history keeps every version", func(t *testing.T) {
+		status, body := do("alice", http.MethodGet, "/api/urls/edit/history", "")
+		require.Equal(t, http.StatusOK, status, body)
+
+		var history []urlVersion
+		require.NoError(t, json.Unmarshal([]byte(body), &history))
+		require.Len(t, history, 3)
+		for i, want := range []string{"https://example.com/v1", "https://example.com/v2", "https://example.com/v1"} {
+			assert.Equal(t, i+1, history[i].Version)
+			assert.Equal(t, want, history[i].OriginalURL)
+		}
+		// The file storage doesn't know when the URL was created
+		assert.Nil(t, history[0].CreatedAt)
+		assert.NotNil(t, history[1].CreatedAt)
+
+		status, _ = do("bob", http.MethodGet, "/api/urls/edit/history", "")
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("The URL which has never been edited has a single version", func(t *testing.T) {
+		status, body := do("bob", http.MethodGet, "/api/urls/taken/history", "")
+		require.Equal(t, http.StatusOK, status, body)
+		assert.JSONEq(t, `[{"version":1,"original_url":"https://example.com/taken"}]`, body)
+	})
+
+	t.Run("The old destination can be shortened again", func(t *testing.T) {
+		status, body := do("alice", http.MethodPatch, "/api/urls/edit", `{"original_url":"https://example.com/v3"}`)
+		require.Equal(t, http.StatusOK, status, body)
+
+		status, body = do("bob", http.MethodPost, "/api/shorten", `{"url":"https://example.com/v1"}`)
+		assert.Equal(t, http.StatusCreated, status, body)
+	})
+}
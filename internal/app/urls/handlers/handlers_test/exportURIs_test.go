@@ -0,0 +1,149 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The user_id cookie of the known user
+func authCookie(userID string, secret string) *http.Cookie {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userID))
+	return &http.Cookie{Name: "user_id", Value: userID + "." + hex.EncodeToString(mac.Sum(nil))}
+}
+
+func Test_ExportURIs(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "http://localhost:8080")
+	config.SecretKey = "secret"
+	config.AdminUsers = "root, admin"
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+	for _, u := range []struct{ longURL, key, userID string }{
+		{"https://example.com/1", "key1", "alice"},
+		{"https://example.com/2", "key2", "alice"},
+		{"https://example.com/3", "key3", "bob"},
+	} {
+		url, err := urlsDomain.NewURL(u.longURL, u.key, u.key, u.userID)
+		require.NoError(t, err)
+		require.NoError(t, urlsRepo.SaveURL(context.Background(), url))
+	}
+	require.NoError(t, urlsRepo.DeleteURLs(context.Background(), []urlsInfra.DeleteRequest{{UserID: "alice", Key: "key2"}}))
+
+	router := chi.NewRouter()
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		userID          string
+		accept          string
+		wantStatus      int
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "The user's links as NDJSON",
+			userID:          "alice",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody:        `{"key":"key1","short_url":"http://localhost:8080/key1","original_url":"https://example.com/1","correlation_id":"key1"}` + "\n",
+		},
+		{
+			name:            "The user's links as CSV",
+			userID:          "alice",
+			accept:          "text/csv;q=0.9, application/json",
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/csv",
+			wantBody:        "key,short_url,original_url,correlation_id,expires_at\nkey1,http://localhost:8080/key1,https://example.com/1,key1,\n",
+		},
+		{
+			name:            "The admin gets the links of everyone with their owners",
+			userID:          "admin",
+			accept:          "text/csv",
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/csv",
+			wantBody: "key,short_url,original_url,correlation_id,expires_at,user_id\n" +
+				"key1,http://localhost:8080/key1,https://example.com/1,key1,,alice\n" +
+				"key3,http://localhost:8080/key3,https://example.com/3,key3,,bob\n",
+		},
+		{
+			name:            "The user without links",
+			userID:          "carol",
+			accept:          "*/*",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantBody:        "",
+		},
+		{
+			name:       "Unsupported format",
+			userID:     "alice",
+			accept:     "application/xml",
+			wantStatus: http.StatusNotAcceptable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/export", nil)
+			require.NoError(t, err)
+			req.AddCookie(authCookie(tt.userID, config.SecretKey))
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			assert.Equal(t, tt.wantContentType, resp.Header.Get("Content-Type"))
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(body))
+		})
+	}
+
+	t.Run("Exported links can be imported", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/export", nil)
+		require.NoError(t, err)
+		req.AddCookie(authCookie("bob", config.SecretKey))
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		export, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+
+		req, err = http.NewRequest(http.MethodPost, ts.URL+"/api/import", strings.NewReader(string(export)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		req.AddCookie(authCookie("bob", config.SecretKey))
+		resp, err = ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var report struct {
+			Duplicate int `json:"duplicate"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		assert.Equal(t, 1, report.Duplicate)
+	})
+}
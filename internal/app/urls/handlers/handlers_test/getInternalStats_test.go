@@ -0,0 +1,111 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetInternalStats(t *testing.T) {
+	urlsRepo := urlsInfra.NewInMemoryRepo(newMockConfig("127.0.0.1:8080", ""))
+	for _, u := range []struct{ longURL, key, userID string }{
+		{"https://example.com/1", "key1", "alice"},
+		{"https://example.com/2", "key2", "alice"},
+		{"https://example.com/3", "key3", "bob"},
+		{"https://example.com/4", "key4", "carol"},
+	} {
+		url, err := urlsDomain.NewURL(u.longURL, u.key, u.key, u.userID)
+		require.NoError(t, err)
+		require.NoError(t, urlsRepo.SaveURL(context.Background(), url))
+	}
+	require.NoError(t, urlsRepo.DeleteURLs(context.Background(), []urlsInfra.DeleteRequest{{UserID: "carol", Key: "key4"}}))
+
+	tests := []struct {
+		name           string
+		trustedSubnet  string
+		trustedProxies string
+		headers        map[string]string
+		wantStatus     int
+	}{
+		{
+			name:       "No trusted subnet",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:          "The client is in the trusted subnet",
+			trustedSubnet: "127.0.0.0/8",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "The header of an untrusted proxy",
+			trustedSubnet: "10.0.0.0/8",
+			headers:       map[string]string{"X-Real-IP": "10.0.0.5"},
+			wantStatus:    http.StatusForbidden,
+		},
+		{
+			name:           "X-Real-IP of a trusted proxy",
+			trustedSubnet:  "10.0.0.0/8",
+			trustedProxies: "127.0.0.1/32",
+			headers:        map[string]string{"X-Real-IP": "10.0.0.5"},
+			wantStatus:     http.StatusOK,
+		},
+		{
+			name:           "X-Forwarded-For through trusted proxies",
+			trustedSubnet:  "10.0.0.0/8",
+			trustedProxies: "127.0.0.1/32,192.168.0.0/16",
+			headers:        map[string]string{"X-Forwarded-For": "10.0.0.5, 192.168.1.1"},
+			wantStatus:     http.StatusOK,
+		},
+		{
+			name:           "X-Forwarded-For spoofed by the client",
+			trustedSubnet:  "10.0.0.0/8",
+			trustedProxies: "127.0.0.1/32",
+			headers:        map[string]string{"X-Forwarded-For": "10.0.0.5, 172.16.0.1"},
+			wantStatus:     http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			config := newMockConfig("127.0.0.1:8080", "")
+			config.TrustedSubnet = tc.trustedSubnet
+			config.TrustedProxies = tc.trustedProxies
+
+			urls.Setup(router, urlsRepo, config)
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/internal/stats", nil)
+			require.NoError(t, err)
+			for name, value := range tc.headers {
+				req.Header.Set(name, value)
+			}
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, tc.wantStatus, resp.StatusCode)
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var stats struct {
+				URLs  int `json:"urls"`
+				Users int `json:"users"`
+			}
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+			assert.Equal(t, 3, stats.URLs)
+			assert.Equal(t, 2, stats.Users)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type urlStats struct {
+	TotalClicks    int `json:"total_clicks"`
+	UniqueVisitors int `json:"unique_visitors"`
+	TopReferrers   []struct {
+		Value  string `json:"value"`
+		Clicks int    `json:"clicks"`
+	} `json:"top_referrers"`
+	Daily []struct {
+		Date   string `json:"date"`
+		Clicks int    `json:"clicks"`
+	} `json:"daily"`
+}
+
+func Test_GetURIStats(t *testing.T) {
+	router := chi.NewRouter()
+
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.SecretKey = "secret"
+	config.TrustedProxies = "127.0.0.1/32"
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	newClient := func() *http.Client {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		return &http.Client{
+			Transport: ts.Client().Transport,
+			Jar:       jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	owner, stranger := newClient(), newClient()
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/?alias=stats", strings.NewReader("https://example.com"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := owner.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Three clicks with two different referrers, the last one comes from another client through the proxy
+	for i, referrer := range []string{"https://example.org", "https://example.org", "https://example.net"} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/stats", nil)
+		require.NoError(t, err)
+		req.Header.Set("Referer", referrer)
+		if i == 2 {
+			req.Header.Set("X-Real-IP", "10.0.0.1")
+		}
+		resp, err := stranger.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+	}
+
+	t.Run("Owner gets the statistics", func(t *testing.T) {
+		var stats urlStats
+		assert.Eventually(t, func() bool {
+			resp, err := owner.Get(ts.URL + "/api/urls/stats/stats")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+			return stats.TotalClicks == 3
+		}, 3*time.Second, 100*time.Millisecond)
+
+		assert.Equal(t, 2, stats.UniqueVisitors)
+		require.Len(t, stats.TopReferrers, 2)
+		assert.Equal(t, "https://example.org", stats.TopReferrers[0].Value)
+		assert.Equal(t, 2, stats.TopReferrers[0].Clicks)
+		require.Len(t, stats.Daily, 1)
+		assert.Equal(t, time.Now().UTC().Format("2006-01-02"), stats.Daily[0].Date)
+	})
+
+	t.Run("Another user can't see the statistics", func(t *testing.T) {
+		resp, err := stranger.Get(ts.URL + "/api/urls/stats/stats")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Unknown key", func(t *testing.T) {
+		resp, err := owner.Get(ts.URL + "/api/urls/unknown/stats")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+// A generated secret key changes on every start, so the visitors hashed with it can't be counted
+func Test_GetURIStatsWithGeneratedSecretKey(t *testing.T) {
+	router := chi.NewRouter()
+
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.SecretKey = "secret"
+	config.SecretKeyGenerated = true
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{
+		Transport: ts.Client().Transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/?alias=stats", strings.NewReader("https://example.com"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, err = client.Get(ts.URL + "/stats")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTemporaryRedirect, resp.StatusCode)
+
+	var stats urlStats
+	assert.Eventually(t, func() bool {
+		resp, err := client.Get(ts.URL + "/api/urls/stats/stats")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+		return stats.TotalClicks == 1
+	}, 3*time.Second, 100*time.Millisecond)
+
+	assert.Equal(t, 0, stats.UniqueVisitors)
+}
@@ -1,6 +1,8 @@
 package handlers_test
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -38,7 +40,7 @@ func Test_GetURI(t *testing.T) {
 	router := chi.NewRouter()
 	router.Use(middleware.AllowContentType("text/plain"))
 
-	config := newMockConfig("127.0.0.1:8080", "path")
+	config := newMockConfig("127.0.0.1:8080", "")
 
 	urlsRepo := urlsInfra.NewInMemoryRepo(config)
 
@@ -79,7 +81,7 @@ func Test_GetURI(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// Adding a shortened URL at /path
-			req, _ := testPostRequest(t, ts, "POST", "/", "text/plain", "https://example.com")
+			req, _ := testPostRequest(t, ts, "POST", "/?alias=path", "text/plain", "https://example.com")
 			req.Body.Close()
 
 			req, _ = testGetRequest(t, ts, tc.method, tc.args.requestPath)
@@ -90,3 +92,46 @@ func Test_GetURI(t *testing.T) {
 	}
 
 }
+
+// A repository which fails every call with the specified error
+type failingRepo struct {
+	urlsInfra.InMemoryRepo
+	err error
+}
+
+func (r *failingRepo) GetURL(_ context.Context, _ string) (string, error) {
+	return "", r.err
+}
+
+func Test_GetURIStorageErrors(t *testing.T) {
+	testCases := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{
+			name:         "Storage timeout",
+			err:          fmt.Errorf("%w: %w", urlsInfra.ErrStorageTimeout, context.DeadlineExceeded),
+			expectedCode: http.StatusGatewayTimeout,
+		},
+		{
+			name:         "Storage unavailable",
+			err:          urlsInfra.ErrStorageUnavailable,
+			expectedCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := chi.NewRouter()
+			config := newMockConfig("127.0.0.1:8080", "")
+
+			urls.Setup(router, &failingRepo{err: tc.err}, config)
+			ts := httptest.NewServer(router)
+			defer ts.Close()
+
+			resp, _ := testGetRequest(t, ts, http.MethodGet, "/anything")
+			assert.Equal(t, tc.expectedCode, resp.StatusCode)
+		})
+	}
+}
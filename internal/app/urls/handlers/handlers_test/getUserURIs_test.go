@@ -0,0 +1,82 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetUserURIs(t *testing.T) {
+	router := chi.NewRouter()
+
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.SecretKey = "secret"
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	newClient := func() *http.Client {
+		jar, err := cookiejar.New(nil)
+		require.NoError(t, err)
+		return &http.Client{Transport: ts.Client().Transport, Jar: jar}
+	}
+	owner, stranger := newClient(), newClient()
+
+	// The owner gets a cookie on the first request and shortens a URL with it
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/", strings.NewReader("https://example.com"))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := owner.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	t.Run("Owner gets the shortened URL", func(t *testing.T) {
+		resp, err := owner.Get(ts.URL + "/api/user/urls")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var userURLs []struct {
+			ShortURL    string `json:"short_url"`
+			OriginalURL string `json:"original_url"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&userURLs))
+		require.Len(t, userURLs, 1)
+		assert.Equal(t, "https://example.com", userURLs[0].OriginalURL)
+	})
+
+	t.Run("Another user has no URLs", func(t *testing.T) {
+		resp, err := stranger.Get(ts.URL + "/api/user/urls")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	})
+
+	t.Run("Forged cookie is replaced", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/user/urls", nil)
+		require.NoError(t, err)
+		req.AddCookie(&http.Cookie{Name: "user_id", Value: "someone.forged"})
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.NotEmpty(t, resp.Cookies())
+	})
+}
@@ -0,0 +1,164 @@
+package handlers_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	"github.com/nomardt/urlshortener-x/internal/app/urls/handlers"
+	"github.com/nomardt/urlshortener-x/internal/infra/ratelimit"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	pb "github.com/nomardt/urlshortener-x/pkg/shortenerpb"
+)
+
+func newGRPCClient(t *testing.T, opts ...handlers.Option) pb.ShortenerClient {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.SecretKey = "secret"
+
+	handler := handlers.NewHandler(urlsInfra.NewInMemoryRepo(config), config, opts...)
+	t.Cleanup(handler.Close)
+
+	server := urls.SetupGRPC(handler, config)
+	listener := bufconn.Listen(1024 * 1024)
+	go server.Serve(listener) //nolint:errcheck
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewShortenerClient(conn)
+}
+
+func Test_GRPCShorten(t *testing.T) {
+	client := newGRPCClient(t)
+	ctx := context.Background()
+
+	// The server gives out the token of the new user in the header
+	var header metadata.MD
+	resp, err := client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com", Alias: "grpc"}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:8080/grpc", resp.GetShortUrl())
+	tokens := header.Get("user_id")
+	require.Len(t, tokens, 1)
+	ownerCtx := metadata.AppendToOutgoingContext(ctx, "user_id", tokens[0])
+
+	tests := []struct {
+		name string
+		req  *pb.ShortenRequest
+		code codes.Code
+	}{
+		{
+			name: "Already shortened URL",
+			req:  &pb.ShortenRequest{Url: "https://example.com"},
+			code: codes.AlreadyExists,
+		},
+		{
+			name: "Taken alias",
+			req:  &pb.ShortenRequest{Url: "https://example.org", Alias: "grpc"},
+			code: codes.AlreadyExists,
+		},
+		{
+			name: "Invalid URL",
+			req:  &pb.ShortenRequest{Url: "example"},
+			code: codes.InvalidArgument,
+		},
+		{
+			name: "Invalid expiry",
+			req:  &pb.ShortenRequest{Url: "https://example.net", ExpiresIn: -1},
+			code: codes.InvalidArgument,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := client.Shorten(ownerCtx, tc.req)
+			assert.Equal(t, tc.code, status.Code(err))
+		})
+	}
+
+	t.Run("Resolve", func(t *testing.T) {
+		resp, err := client.Resolve(ctx, &pb.ResolveRequest{Key: "grpc"})
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com", resp.GetOriginalUrl())
+
+		_, err = client.Resolve(ctx, &pb.ResolveRequest{Key: "unknown"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("Owner lists the URLs", func(t *testing.T) {
+		resp, err := client.ListUserURLs(ownerCtx, &pb.ListUserURLsRequest{})
+		require.NoError(t, err)
+		require.Len(t, resp.GetUrls(), 1)
+		assert.Equal(t, "https://example.com", resp.GetUrls()[0].GetOriginalUrl())
+
+		resp, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+		require.NoError(t, err)
+		assert.Empty(t, resp.GetUrls())
+	})
+}
+
+func Test_GRPCShortenBatch(t *testing.T) {
+	client := newGRPCClient(t)
+	ctx := context.Background()
+
+	resp, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Urls: []*pb.ShortenBatchRequest_URL{
+		{CorrelationId: "1", OriginalUrl: "https://example.com", Alias: "first"},
+		{CorrelationId: "2", OriginalUrl: "https://example.org", Alias: "second"},
+	}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetUrls(), 2)
+	assert.Equal(t, "http://127.0.0.1:8080/second", resp.GetUrls()[1].GetShortUrl())
+
+	// Test case: A correlation ID which is already stored fails the whole batch
+	_, err = client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Urls: []*pb.ShortenBatchRequest_URL{
+		{CorrelationId: "3", OriginalUrl: "https://example.net", Alias: "third"},
+		{CorrelationId: "1", OriginalUrl: "https://example.info"},
+	}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.Resolve(ctx, &pb.ResolveRequest{Key: "third"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func Test_GRPCRateLimit(t *testing.T) {
+	client := newGRPCClient(t, handlers.WithRateLimitStore(ratelimit.NewMemoryStore(ratelimit.PerMinute(60, 3))))
+	ctx := context.Background()
+
+	// Test case: The batch costs a token per URL
+	_, err := client.ShortenBatch(ctx, &pb.ShortenBatchRequest{Urls: []*pb.ShortenBatchRequest_URL{
+		{CorrelationId: "1", OriginalUrl: "https://example.com/1"},
+		{CorrelationId: "2", OriginalUrl: "https://example.com/2"},
+	}})
+	require.NoError(t, err)
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com/3"})
+	require.NoError(t, err)
+
+	// Test case: The bucket is empty, the client is told how long to wait
+	_, err = client.Shorten(ctx, &pb.ShortenRequest{Url: "https://example.com/4"})
+	st := status.Convert(err)
+	require.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 1)
+	retryInfo, ok := st.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	assert.Positive(t, retryInfo.GetRetryDelay().AsDuration())
+
+	// Test case: The reads aren't limited
+	_, err = client.ListUserURLs(ctx, &pb.ListUserURLsRequest{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,210 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type importReport struct {
+	Created   int `json:"created"`
+	Duplicate int `json:"duplicate"`
+	Conflict  int `json:"conflict"`
+	Invalid   int `json:"invalid"`
+	Skipped   int `json:"skipped"`
+	Rows      []struct {
+		Row      int    `json:"row"`
+		Status   string `json:"status"`
+		ShortURL string `json:"short_url"`
+	} `json:"rows"`
+	StoppedAt int    `json:"stopped_at"`
+	Error     string `json:"error"`
+}
+
+func Test_ImportURIs(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "http://localhost:8080")
+	config.SecretKey = "secret"
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+	url, err := urlsDomain.NewURL("https://example.com/existing", "existing", "existing", "bob")
+	require.NoError(t, err)
+	require.NoError(t, urlsRepo.SaveURL(context.Background(), url))
+
+	router := chi.NewRouter()
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	type wantRow struct {
+		status   string
+		shortURL string
+	}
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantRows    []wantRow
+		// The import which stops gets the report as well
+		wantStoppedAt int
+	}{
+		{
+			name:        "NDJSON and the lines of the storage file",
+			contentType: "application/x-ndjson",
+			body: `{"key":"first","original_url":"https://example.com/first"}
+{"correlation_id":"1","short_url":"second","original_url":"https://example.com/second","user_id":"someone"}
+
+{"short_url":"http://localhost:8080/third","original_url":"https://example.com/third"}
+{"original_url":"https://example.com/existing"}
+{"key":"existing","original_url":"https://example.com/other"}
+{"original_url":"not a url"}
+{"original_url":
+{"short_url":"deleted","original_url":"https://example.com/deleted","is_deleted":true}
+`,
+			wantStatus: http.StatusOK,
+			wantRows: []wantRow{
+				{"created", "http://localhost:8080/first"},
+				{"created", "http://localhost:8080/second"},
+				{"created", "http://localhost:8080/third"},
+				{"duplicate", "http://localhost:8080/existing"},
+				{"conflict", ""},
+				{"invalid", ""},
+				{"invalid", ""},
+				{"skipped", ""},
+			},
+		},
+		{
+			name:        "CSV",
+			contentType: "text/csv; charset=utf-8",
+			body:        "original_url,key,expires_at\nhttps://example.com/csv,csv,2999-01-01T00:00:00Z\nhttps://example.com/past,,2000-01-01T00:00:00Z\nhttps://example.com/first,,\n",
+			wantStatus:  http.StatusOK,
+			wantRows: []wantRow{
+				{"created", "http://localhost:8080/csv"},
+				{"invalid", ""},
+				{"duplicate", "http://localhost:8080/first"},
+			},
+		},
+		{
+			name:        "CSV without the original URLs",
+			contentType: "text/csv",
+			body:        "key,short_url\nkey,http://localhost:8080/key\n",
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:          "Too many rows",
+			contentType:   "application/x-ndjson",
+			body:          strings.Repeat("{}\n", 10001),
+			wantStatus:    http.StatusRequestEntityTooLarge,
+			wantStoppedAt: 10001,
+		},
+		{
+			name:          "Too large body",
+			contentType:   "application/x-ndjson",
+			body:          "{\"key\":\"large\",\"original_url\":\"https://example.com/large\"}\n" + strings.Repeat("\n", 32<<20),
+			wantStatus:    http.StatusRequestEntityTooLarge,
+			wantRows:      []wantRow{{"created", "http://localhost:8080/large"}},
+			wantStoppedAt: 2,
+		},
+		{
+			name:        "Unsupported format",
+			contentType: "application/json",
+			body:        `[{"original_url":"https://example.com"}]`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := testPostRequest(t, ts, http.MethodPost, "/api/import", tt.contentType, tt.body)
+			defer resp.Body.Close()
+
+			require.Equal(t, tt.wantStatus, resp.StatusCode, body)
+			if tt.wantStatus != http.StatusOK && tt.wantStoppedAt == 0 {
+				return
+			}
+
+			var report importReport
+			require.NoError(t, json.Unmarshal([]byte(body), &report))
+			assert.Equal(t, tt.wantStoppedAt, report.StoppedAt)
+			assert.Equal(t, tt.wantStoppedAt != 0, report.Error != "")
+			if tt.wantRows == nil {
+				assert.Len(t, report.Rows, tt.wantStoppedAt-1)
+				return
+			}
+			require.Len(t, report.Rows, len(tt.wantRows))
+			for i, want := range tt.wantRows {
+				assert.Equal(t, i+1, report.Rows[i].Row)
+				assert.Equal(t, want.status, report.Rows[i].Status, "row %d", i+1)
+				assert.Equal(t, want.shortURL, report.Rows[i].ShortURL, "row %d", i+1)
+			}
+		})
+	}
+
+	// The imported URLs work and keep their keys
+	originalURL, err := urlsRepo.GetURL(context.Background(), "second")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/second", originalURL)
+	_, err = urlsRepo.GetURL(context.Background(), "deleted")
+	assert.ErrorIs(t, err, urlsInfra.ErrNotFoundURL)
+
+	// Test case: The report counts every status
+	resp, body := testPostRequest(t, ts, http.MethodPost, "/api/import", "application/x-ndjson",
+		"{\"original_url\":\"https://example.com/counted\"}\n{\"original_url\":\"https://example.com/existing\"}\n")
+	defer resp.Body.Close()
+	var report importReport
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Duplicate)
+	assert.Equal(t, 0, report.Invalid)
+	assert.True(t, strings.HasPrefix(report.Rows[0].ShortURL, "http://localhost:8080/"))
+}
+
+// A repository which fails to save the URLs once it has saved the specified number of them
+type failingSaveRepo struct {
+	*urlsInfra.InMemoryRepo
+	saved     int
+	failAfter int
+}
+
+func (r *failingSaveRepo) SaveURL(ctx context.Context, url *urlsDomain.URL) error {
+	if r.saved == r.failAfter {
+		return urlsInfra.ErrStorageUnavailable
+	}
+	r.saved++
+	return r.InMemoryRepo.SaveURL(ctx, url)
+}
+
+func Test_ImportURIsStorageFailure(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "http://localhost:8080")
+	config.SecretKey = "secret"
+
+	router := chi.NewRouter()
+	urls.Setup(router, &failingSaveRepo{InMemoryRepo: urlsInfra.NewInMemoryRepo(config), failAfter: 1}, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Test case: The import stops at the row which can't be saved, the rows before it are in the report
+	resp, body := testPostRequest(t, ts, http.MethodPost, "/api/import", "application/x-ndjson",
+		"{\"key\":\"saved\",\"original_url\":\"https://example.com/saved\"}\n{\"original_url\":\"https://example.com/failed\"}\n{\"original_url\":\"https://example.com/never\"}\n")
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, body)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var report importReport
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.Equal(t, 2, report.StoppedAt)
+	assert.NotEmpty(t, report.Error)
+	assert.Equal(t, 1, report.Created)
+	require.Len(t, report.Rows, 1)
+	assert.Equal(t, "http://localhost:8080/saved", report.Rows[0].ShortURL)
+}
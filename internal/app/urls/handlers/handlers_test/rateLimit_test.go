@@ -0,0 +1,173 @@
+package handlers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_RateLimit(t *testing.T) {
+	router := chi.NewRouter()
+
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.RateLimit = 60
+	config.RateBurst = 3
+	config.TrustedProxies = "127.0.0.1/32"
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Test case: The malformed and the oversized batches are rejected without taking any tokens
+	resp, _ := testPostRequest(t, ts, http.MethodPost, "/api/shorten/batch", "application/json", `{"original_url": "https://example.com/1"`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = testPostRequest(t, ts, http.MethodPost, "/api/shorten/batch", "application/json", "["+strings.Repeat(" ", 8<<20)+"]")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Test case: The batch costs a token per URL
+	resp, _ = testPostRequest(t, ts, http.MethodPost, "/api/shorten/batch", "application/json",
+		`[{"correlation_id": "1", "original_url": "https://example.com/1"}, {"correlation_id": "2", "original_url": "https://example.com/2"}]`)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, _ = testPostRequest(t, ts, http.MethodPost, "/", "text/plain", "https://example.com/3")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	// Test case: The bucket of the IP is empty
+	resp, _ = testPostRequest(t, ts, http.MethodPost, "/api/shorten", "application/json", `{"url": "https://example.com/4"}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	// Test case: The redirects aren't limited
+	resp, _ = testPostRequest(t, ts, http.MethodGet, "/unknown", "", "")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	cookies := resp.Cookies()
+	require.NotEmpty(t, cookies)
+
+	// Test case: The new cookie doesn't give a fresh bucket
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten", strings.NewReader(`{"url": "https://example.com/5"}`))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(cookies[0])
+	resp, err = ts.Client().Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Test case: The known user is limited across the IPs
+	for i, want := range []int{http.StatusCreated, http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/shorten", strings.NewReader(fmt.Sprintf(`{"url": "https://example.org/%d"}`, i)))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Real-IP", fmt.Sprintf("10.0.0.%d", i+1))
+		req.AddCookie(cookies[0])
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, want, resp.StatusCode)
+	}
+
+	// Test case: The batch is larger than the burst
+	resp, _ = testPostRequest(t, ts, http.MethodPost, "/api/shorten/batch", "application/json",
+		`[{"correlation_id": "5", "original_url": "https://example.net/1"}, {"correlation_id": "6", "original_url": "https://example.net/2"},
+		{"correlation_id": "7", "original_url": "https://example.net/3"}, {"correlation_id": "8", "original_url": "https://example.net/4"}]`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func Test_RateLimitRefund(t *testing.T) {
+	router := chi.NewRouter()
+
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.RateLimit = 60
+	config.RateBurst = 3
+	config.TrustedProxies = "127.0.0.1/32"
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	resp, _ := testPostRequest(t, ts, http.MethodGet, "/unknown", "", "")
+	cookies := resp.Cookies()
+	require.NotEmpty(t, cookies)
+
+	shorten := func(ip string, url string, cookie *http.Cookie) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/", strings.NewReader(url))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Real-IP", ip)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// The bucket of the user is spent from the other IPs
+	for i := 1; i <= 3; i++ {
+		require.Equal(t, http.StatusCreated, shorten(fmt.Sprintf("10.0.0.%d", i), fmt.Sprintf("https://example.com/%d", i), cookies[0]))
+	}
+
+	// Test case: The user bucket rejects the request, the IP bucket keeps its tokens
+	assert.Equal(t, http.StatusTooManyRequests, shorten("10.0.1.1", "https://example.com/rejected", cookies[0]))
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, http.StatusCreated, shorten("10.0.1.1", fmt.Sprintf("https://example.org/%d", i), nil))
+	}
+}
+
+func Test_RateLimitImport(t *testing.T) {
+	router := chi.NewRouter()
+
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.RateLimit = 60
+	config.RateBurst = 3
+
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+
+	urls.Setup(router, urlsRepo, config)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	// Test case: The import costs a token per row, the header and the blank lines are free
+	resp, _ := testPostRequest(t, ts, http.MethodPost, "/api/import", "text/csv",
+		"original_url\nhttps://example.com/1\n\nhttps://example.com/2\n")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = testPostRequest(t, ts, http.MethodPost, "/", "text/plain", "https://example.com/3")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+	resp, _ = testPostRequest(t, ts, http.MethodPost, "/", "text/plain", "https://example.com/4")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	// Test case: The import stops at the row the client has no token for, the rows before it are imported
+	router = chi.NewRouter()
+	urls.Setup(router, urlsInfra.NewInMemoryRepo(config), config)
+	ts = httptest.NewServer(router)
+	defer ts.Close()
+
+	resp, body := testPostRequest(t, ts, http.MethodPost, "/api/import", "application/x-ndjson",
+		"{\"original_url\": \"https://example.com/1\"}\n{\"original_url\": \"https://example.com/2\"}\n"+
+			"{\"original_url\": \"https://example.com/3\"}\n{\"original_url\": \"https://example.com/4\"}\n")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+
+	var report importReport
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	assert.Equal(t, 3, report.Created)
+	assert.Equal(t, 4, report.StoppedAt)
+}
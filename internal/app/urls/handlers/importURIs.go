@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+	"github.com/nomardt/urlshortener-x/internal/domain/urls"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/ratelimit"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"go.uber.org/zap"
+)
+
+// The longest line of the NDJSON import
+const maxImportLineSize = 1 << 20
+
+// The largest import, the rest of the body isn't read
+const maxImportBodySize = 32 << 20
+
+// The most rows a single import can have, the report keeps the result of every one of them
+const maxImportRows = 10000
+
+const (
+	importCreated   = "created"
+	importDuplicate = "duplicate"
+	importConflict  = "conflict"
+	importInvalid   = "invalid"
+	importSkipped   = "skipped"
+)
+
+var errNoOriginalURLColumn = errors.New("the CSV header has no original_url column")
+
+// A row of the import. Both the exported URLs and the lines of the storage file fit in it,
+// the storage file keeps the key in short_url
+type importedURL struct {
+	Key         string     `json:"key"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	IsDeleted   bool       `json:"is_deleted"`
+}
+
+// The key the URL is imported with, the empty key is generated randomly
+func (u importedURL) key() string {
+	if u.Key != "" || u.ShortURL == "" {
+		return u.Key
+	}
+	if parsed, err := url.Parse(u.ShortURL); err == nil && parsed.Scheme != "" {
+		return path.Base(parsed.Path)
+	}
+	return u.ShortURL
+}
+
+type importRowResult struct {
+	Row      int    `json:"row"`
+	Status   string `json:"status"`
+	ShortURL string `json:"short_url,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type importReport struct {
+	Created   int               `json:"created"`
+	Duplicate int               `json:"duplicate"`
+	Conflict  int               `json:"conflict"`
+	Invalid   int               `json:"invalid"`
+	Skipped   int               `json:"skipped"`
+	Rows      []importRowResult `json:"rows"`
+	// The row the import stopped at and why, the rows before it are imported
+	StoppedAt int    `json:"stopped_at,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func (r *importReport) add(result importRowResult) {
+	switch result.Status {
+	case importCreated:
+		r.Created++
+	case importDuplicate:
+		r.Duplicate++
+	case importConflict:
+		r.Conflict++
+	case importInvalid:
+		r.Invalid++
+	case importSkipped:
+		r.Skipped++
+	}
+	r.Rows = append(r.Rows, result)
+}
+
+// Stop the import at the row, the status of the response is returned
+func (r *importReport) stop(row int, status int, reason string) int {
+	r.StoppedAt = row
+	r.Error = reason
+
+	return status
+}
+
+// Reads the rows of the import one by one, io.EOF ends the import
+type importReader func() (importedURL, error)
+
+// The row which can't be parsed, the import goes on with the next one
+type invalidRowError struct {
+	err error
+}
+
+func (e *invalidRowError) Error() string {
+	return e.err.Error()
+}
+
+func newNDJSONImportReader(body io.Reader) importReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineSize)
+
+	return func() (importedURL, error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			var imported importedURL
+			if err := json.Unmarshal([]byte(line), &imported); err != nil {
+				return importedURL{}, &invalidRowError{err: fmt.Errorf("invalid JSON: %w", err)}
+			}
+			return imported, nil
+		}
+
+		if err := scanner.Err(); err != nil {
+			return importedURL{}, err
+		}
+		return importedURL{}, io.EOF
+	}
+}
+
+// The CSV must start with the header, the columns are found by their names
+func newCSVImportReader(body io.Reader) (importReader, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the CSV header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, found := columns["original_url"]; !found {
+		return nil, errNoOriginalURLColumn
+	}
+
+	field := func(record []string, name string) string {
+		if i, found := columns[name]; found && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	return func() (importedURL, error) {
+		record, err := reader.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return importedURL{}, &invalidRowError{err: err}
+		} else if err != nil {
+			return importedURL{}, err
+		}
+
+		imported := importedURL{
+			Key:         field(record, "key"),
+			ShortURL:    field(record, "short_url"),
+			OriginalURL: field(record, "original_url"),
+		}
+		if expiresAt := field(record, "expires_at"); expiresAt != "" {
+			parsed, err := time.Parse(time.RFC3339, expiresAt)
+			if err != nil {
+				return importedURL{}, &invalidRowError{err: errInvalidExpiry}
+			}
+			imported.ExpiresAt = &parsed
+		}
+		return imported, nil
+	}, nil
+}
+
+// Shorten every URL of the NDJSON or CSV body for the user. The rows are saved one by one, so the invalid
+// or conflicting rows don't stop the import, every row gets its result in the report. The import which stops
+// halfway through, e.g. on the failure of the storage, still gets the report of the rows before.
+// Every row takes a token of the rate limit as it is read, the import stops at the row the client has no token for.
+// The correlation IDs aren't imported, the URLs get the new ones
+func (h *Handler) ImportURIs(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportBodySize)
+	mediaType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+
+	var next importReader
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case contentTypeNDJSON, "application/ndjson":
+		next = newNDJSONImportReader(r.Body)
+	case contentTypeCSV:
+		var err error
+		if next, err = newCSVImportReader(r.Body); err != nil {
+			http.Error(w, "Please provide a valid CSV! "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Please use either \"Content-Type: application/x-ndjson\" or \"Content-Type: text/csv\" for this endpoint!", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	userID := middlewares.UserIDFromContext(r.Context())
+	report := importReport{Rows: []importRowResult{}}
+	status := http.StatusOK
+	for row := 1; ; row++ {
+		imported, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if row > maxImportRows {
+			status = report.stop(row, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d rows are imported at once", maxImportRows))
+			break
+		}
+
+		var invalidRow *invalidRowError
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = report.stop(row, http.StatusRequestEntityTooLarge, fmt.Sprintf("the body is larger than %d bytes", maxBytesErr.Limit))
+			break
+		} else if err != nil && !errors.As(err, &invalidRow) {
+			status = report.stop(row, http.StatusBadRequest, "couldn't read the row: "+err.Error())
+			break
+		}
+
+		// Every row takes a token, the invalid ones as well
+		allowed, retryAfter, limitErr := middlewares.TakeTokens(r, h.limits, 1)
+		if errors.Is(limitErr, ratelimit.ErrExceedsBurst) {
+			status = report.stop(row, http.StatusRequestEntityTooLarge, "the rate limit allows no rows at all")
+			break
+		} else if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			status = report.stop(row, http.StatusTooManyRequests, "too many requests, please try again later")
+			break
+		}
+
+		if invalidRow != nil {
+			report.add(importRowResult{Row: row, Status: importInvalid, Error: err.Error()})
+			continue
+		}
+
+		result, err := h.importURL(r, userID, imported)
+		if err != nil {
+			errStatus := storageErrorStatus(err)
+			if errStatus == 0 {
+				errStatus = http.StatusInternalServerError
+				logger.Log.Info("Couldn't import the URL", zap.Error(err))
+			}
+			status = report.stop(row, errStatus, "couldn't save the URL: "+strings.ToLower(http.StatusText(errStatus)))
+			break
+		}
+		result.Row = row
+		report.add(result)
+	}
+	logger.Log.Info("Imported URLs", zap.Int("created", report.Created), zap.Int("rows", len(report.Rows)), zap.Int("stopped_at", report.StoppedAt))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.Log.Info("Couldn't send the import report", zap.Error(err))
+	}
+}
+
+// Save the single row. Only the failure of the storage is returned as the error, the rest is the result of the row
+func (h *Handler) importURL(r *http.Request, userID string, imported importedURL) (importRowResult, error) {
+	if imported.IsDeleted {
+		return importRowResult{Status: importSkipped, Error: "the link is deleted"}, nil
+	}
+
+	expiresAt, err := parseExpiry(0, imported.ExpiresAt)
+	if err != nil {
+		return importRowResult{Status: importInvalid, Error: err.Error()}, nil
+	}
+
+	key, err := shortenURL(r.Context(), imported.OriginalURL, h, "", userID, imported.key(), expiresAt)
+	var errURINotUnique *urlsInfra.ErrURINotUnique
+	switch {
+	case err == nil:
+		return importRowResult{Status: importCreated, ShortURL: h.ShortURL(key)}, nil
+	case errors.As(err, &errURINotUnique):
+		return importRowResult{Status: importDuplicate, ShortURL: h.ShortURL(errURINotUnique.ExistingKey)}, nil
+	case errors.Is(err, urlsInfra.ErrKeyNotUnique), errors.Is(err, urlsInfra.ErrCorIDNotUnique):
+		return importRowResult{Status: importConflict, Error: err.Error()}, nil
+	case errors.Is(err, urls.ErrInvalidURL), errors.Is(err, urls.ErrInvalidKey):
+		return importRowResult{Status: importInvalid, Error: err.Error()}, nil
+	default:
+		return importRowResult{}, err
+	}
+}
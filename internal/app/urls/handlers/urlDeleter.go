@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"go.uber.org/zap"
+)
+
+const (
+	deleteBatchSize     = 100
+	deleteQueueSize     = 10000
+	deleteFlushInterval = time.Second
+)
+
+var (
+	errDeleteQueueFull = errors.New("too many deletions are pending, please try again later")
+	errTooManyKeys     = errors.New("too many keys to delete at once")
+	errDeleterClosed   = errors.New("the server is shutting down")
+)
+
+type urlDeleter struct {
+	repo Repository
+
+	// The deletions accepted but not yet written, they are guarded by mu
+	mu      sync.Mutex
+	pending []urlsInfra.DeleteRequest
+	closed  bool
+
+	full chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Start the background worker which deletes URLs in batches
+func newURLDeleter(repo Repository) *urlDeleter {
+	d := &urlDeleter{
+		repo: repo,
+		full: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+
+	d.wg.Add(1)
+	go d.run()
+
+	return d
+}
+
+// Queue the keys for deletion without waiting for the storage. Either all the keys are accepted or none of them,
+// once accepted they are deleted even if the server is shutting down
+func (d *urlDeleter) enqueue(userID string, keys []string) error {
+	if len(keys) > deleteQueueSize {
+		return errTooManyKeys
+	}
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return errDeleterClosed
+	}
+	if len(d.pending)+len(keys) > deleteQueueSize {
+		d.mu.Unlock()
+		return errDeleteQueueFull
+	}
+	for _, key := range keys {
+		d.pending = append(d.pending, urlsInfra.DeleteRequest{UserID: userID, Key: key})
+	}
+	batchReady := len(d.pending) >= deleteBatchSize
+	d.mu.Unlock()
+
+	// The worker doesn't wait for the ticker when there is a whole batch to delete
+	if batchReady {
+		select {
+		case d.full <- struct{}{}:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// Stop accepting the deletions and stop the worker after deleting every URL it has accepted
+func (d *urlDeleter) close() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	close(d.stop)
+	d.wg.Wait()
+}
+
+func (d *urlDeleter) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(deleteFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.full:
+			d.flush()
+		case <-ticker.C:
+			d.flush()
+		case <-d.stop:
+			d.flush()
+			return
+		}
+	}
+}
+
+// Delete all the pending URLs in batches
+func (d *urlDeleter) flush() {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = nil
+	d.mu.Unlock()
+
+	for len(pending) > 0 {
+		batch := pending[:min(len(pending), deleteBatchSize)]
+		pending = pending[len(batch):]
+
+		if err := d.repo.DeleteURLs(context.Background(), batch); err != nil {
+			logger.Log.Info("Couldn't delete URLs", zap.Int("count", len(batch)), zap.Error(err))
+		}
+	}
+}
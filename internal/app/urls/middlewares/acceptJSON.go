@@ -1,35 +1,34 @@
 package middlewares
 
 import (
-	"compress/gzip"
+	"bytes"
 	"encoding/json"
+	"io"
 	"net/http"
 	"strings"
-
-	"github.com/nomardt/urlshortener-x/internal/infra/logger"
-	"go.uber.org/zap"
 )
 
 // This middleware should be used for endpoints designed only for JSON bodies
 func OnlyJSONBody(h http.HandlerFunc) http.HandlerFunc {
 	jsonFn := func(w http.ResponseWriter, r *http.Request) {
 		contentType := r.Header.Get("Content-Type")
-		if strings.Contains(contentType, "application/x-gzip") {
-			rg, err := gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "Bad Request", http.StatusBadRequest)
-				logger.Log.Info("Couldn't decompress request body", zap.String("error", err.Error()))
+		if isGzipLegacy(r) || isGzipEncoded(r) {
+			closeBody, ok := decompressBody(w, r)
+			if !ok {
 				return
 			}
-			defer rg.Close()
+			defer closeBody()
+		}
 
-			var js json.RawMessage
-			if json.NewDecoder(rg).Decode(&js) != nil {
+		if isGzipLegacy(r) {
+			// The content type is unknown, so the body has to be checked
+			body, err := io.ReadAll(r.Body)
+			if err != nil || !json.Valid(body) {
 				http.Error(w, "Please provide valid JSON for this endpoint!", http.StatusUnsupportedMediaType)
 				return
 			}
 
-			r.Body = rg
+			r.Body = io.NopCloser(bytes.NewReader(body))
 		} else if !strings.Contains(contentType, "application/json") {
 			http.Error(w, "Please use only \"Content-Type: application/json\" for this endpoint!", http.StatusUnsupportedMediaType)
 			return
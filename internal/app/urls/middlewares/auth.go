@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"go.uber.org/zap"
+)
+
+const AuthCookieName = "user_id"
+
+type contextKey string
+
+const (
+	userIDKey    contextKey = "userID"
+	knownUserKey contextKey = "knownUser"
+)
+
+// Returns the ID of the user who sent the request. The ID is put in the context by WithAuth
+func UserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey).(string)
+	return userID
+}
+
+// The user ID came signed with the request rather than was just issued to it
+func IsKnownUser(ctx context.Context) bool {
+	known, _ := ctx.Value(knownUserKey).(bool)
+	return known
+}
+
+func withUserID(ctx context.Context, userID string, known bool) context.Context {
+	return context.WithValue(context.WithValue(ctx, userIDKey, userID), knownUserKey, known)
+}
+
+// This middleware checks the signed user ID cookie and gives out a new one if it is absent or invalid
+func WithAuth(secret string) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		authFn := func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := verifyUserID(r, secret)
+			if !ok {
+				userID = uuid.New().String()
+				http.SetCookie(w, &http.Cookie{
+					Name:     AuthCookieName,
+					Value:    signUserID(userID, secret),
+					Path:     "/",
+					HttpOnly: true,
+				})
+				logger.Log.Debug("Issued a new user ID", zap.String("user_id", userID))
+			}
+
+			h.ServeHTTP(w, r.WithContext(withUserID(r.Context(), userID, ok)))
+		}
+
+		return http.HandlerFunc(authFn)
+	}
+}
+
+// The cookie value has the form <user ID>.<hex encoded HMAC-SHA256 of the user ID>
+func signUserID(userID string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(userID))
+	return userID + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyUserID(r *http.Request, secret string) (string, bool) {
+	cookie, err := r.Cookie(AuthCookieName)
+	if err != nil {
+		return "", false
+	}
+
+	return verifyToken(cookie.Value, secret)
+}
+
+func verifyToken(token string, secret string) (string, bool) {
+	userID, _, found := strings.Cut(token, ".")
+	if !found || userID == "" {
+		return "", false
+	}
+
+	if !hmac.Equal([]byte(token), []byte(signUserID(userID, secret))) {
+		return "", false
+	}
+
+	return userID, true
+}
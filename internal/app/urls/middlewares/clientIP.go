@@ -0,0 +1,95 @@
+package middlewares
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const clientIPKey contextKey = "clientIP"
+
+// This middleware finds out the IP of the client. X-Real-IP and X-Forwarded-For are only believed
+// if the request came from one of the trusted proxies, anyone else could put anything in them
+func WithClientIP(trustedProxies []*net.IPNet) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		ipFn := func(w http.ResponseWriter, r *http.Request) {
+			ip := resolveClientIP(r, trustedProxies)
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+		}
+
+		return http.HandlerFunc(ipFn)
+	}
+}
+
+func resolveClientIP(r *http.Request, trustedProxies []*net.IPNet) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !containsIP(trustedProxies, ip) {
+		return ip
+	}
+
+	if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); realIP != nil {
+		return realIP
+	}
+
+	// Every proxy appends the address it got the request from, so the client is the rightmost address
+	// which isn't a trusted proxy
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if forwardedIP == nil {
+			break
+		}
+
+		ip = forwardedIP
+		if !containsIP(trustedProxies, ip) {
+			break
+		}
+	}
+
+	return ip
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return net.ParseIP(host)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The IP found out by WithClientIP or the address the request came from if the middleware isn't used
+func ClientIP(r *http.Request) net.IP {
+	if ip, ok := r.Context().Value(clientIPKey).(net.IP); ok {
+		return ip
+	}
+
+	return remoteIP(r)
+}
+
+// This middleware lets through only the requests from the trusted network. Nothing is let through if it is nil
+func OnlyTrustedSubnet(trusted *net.IPNet) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		trustedFn := func(w http.ResponseWriter, r *http.Request) {
+			if ip := ClientIP(r); trusted == nil || ip == nil || !trusted.Contains(ip) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		}
+
+		return trustedFn
+	}
+}
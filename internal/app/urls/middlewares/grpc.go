@@ -0,0 +1,85 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/google/uuid"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/ratelimit"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// The gRPC counterpart of WithAuth. The signed user ID is read from the user_id metadata
+// and a new one is sent back in the user_id header if it is absent or invalid
+func AuthInterceptor(secret string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		var userID string
+		var ok bool
+		if md, found := metadata.FromIncomingContext(ctx); found {
+			if tokens := md.Get(AuthCookieName); len(tokens) > 0 {
+				userID, ok = verifyToken(tokens[0], secret)
+			}
+		}
+
+		if !ok {
+			userID = uuid.New().String()
+			if err := grpc.SetHeader(ctx, metadata.Pairs(AuthCookieName, signUserID(userID, secret))); err != nil {
+				return nil, err
+			}
+			logger.Log.Debug("Issued a new user ID", zap.String("user_id", userID))
+		}
+
+		return handler(withUserID(ctx, userID, ok), req)
+	}
+}
+
+// The number of tokens the gRPC request takes, the requests which cost nothing aren't limited
+type GRPCRequestCost func(req any) int
+
+// The gRPC counterpart of RateLimit, it shares the buckets with the HTTP routes. The limited client gets
+// ResourceExhausted with the time to wait in RetryInfo. The limiter is disabled if the store is nil
+func RateLimitInterceptor(store ratelimit.Store, cost GRPCRequestCost) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		n := cost(req)
+		if store == nil || n == 0 {
+			return handler(ctx, req)
+		}
+
+		allowed, retryAfter, err := take(ctx, store, rateLimitKeys(ctx, peerIP(ctx)), n)
+		if errors.Is(err, ratelimit.ErrExceedsBurst) {
+			return nil, status.Error(codes.InvalidArgument, "the request is larger than the rate limit allows")
+		} else if !allowed {
+			st := status.New(codes.ResourceExhausted, "too many requests, please try again later")
+			if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+				st = detailed
+			}
+			return nil, st.Err()
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// The address of the gRPC client, the proxy headers aren't believed here
+func peerIP(ctx context.Context) net.IP {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	return net.ParseIP(host)
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"go.uber.org/zap"
+)
+
+// The body is compressed either with "Content-Encoding: gzip" and the real Content-Type
+// or with "Content-Type: application/x-gzip" which says nothing about the content
+func isGzipLegacy(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Content-Type"), "application/x-gzip")
+}
+
+func isGzipEncoded(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip")
+}
+
+// Replace the compressed body with the decompressed one. False is returned if the response has already been written
+func decompressBody(w http.ResponseWriter, r *http.Request) (func(), bool) {
+	rg, err := gzip.NewReader(r.Body)
+	if err != nil {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		logger.Log.Info("Couldn't decompress request body", zap.String("error", err.Error()))
+		return nil, false
+	}
+
+	r.Body = rg
+	r.Header.Del("Content-Encoding")
+	return func() { rg.Close() }, true
+}
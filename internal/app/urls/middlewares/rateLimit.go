@@ -0,0 +1,140 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/metrics"
+	"github.com/nomardt/urlshortener-x/internal/infra/ratelimit"
+	"go.uber.org/zap"
+)
+
+// The largest JSON batch, the body is read whole to count its URLs
+const maxBatchBodySize = 8 << 20
+
+var errInvalidBatch = errors.New("the batch must be a JSON array")
+
+// The number of tokens the request takes from the bucket of the client. The request whose cost
+// can't be found out is rejected without taking anything
+type RequestCost func(r *http.Request) (int, error)
+
+// Every request costs a single token
+func SingleCost(*http.Request) (int, error) {
+	return 1, nil
+}
+
+// Every URL of the JSON batch costs a token, the empty batch costs one as well. The body is left for the handler to read
+func BatchCost(r *http.Request) (int, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxBatchBodySize))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return 0, fmt.Errorf("%w: %w", errInvalidBatch, err)
+	}
+
+	return max(len(batch), 1), nil
+}
+
+// This middleware limits the requests of the IP and, if the user is known, of the user as well. The limiter
+// is disabled if the store is nil. The requests are let through if the store fails
+func RateLimit(store ratelimit.Store, cost RequestCost) func(http.HandlerFunc) http.HandlerFunc {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		if store == nil {
+			return h
+		}
+
+		limitFn := func(w http.ResponseWriter, r *http.Request) {
+			n, err := cost(r)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				http.Error(w, "The request body is too large!", http.StatusRequestEntityTooLarge)
+				return
+			} else if err != nil {
+				http.Error(w, "You provided invalid JSON! "+err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			allowed, retryAfter, err := take(r.Context(), store, rateLimitKeys(r.Context(), ClientIP(r)), n)
+			if errors.Is(err, ratelimit.ErrExceedsBurst) {
+				http.Error(w, "The request is larger than the rate limit allows!", http.StatusRequestEntityTooLarge)
+				return
+			} else if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Too many requests, please try again later!", http.StatusTooManyRequests)
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		}
+
+		return limitFn
+	}
+}
+
+// Take n tokens for the request from the buckets of its client. The handlers which find out the cost of
+// the request as they read it use it instead of the middleware. Everything is allowed if the store is nil
+func TakeTokens(r *http.Request, store ratelimit.Store, n int) (bool, time.Duration, error) {
+	if store == nil {
+		return true, 0, nil
+	}
+
+	return take(r.Context(), store, rateLimitKeys(r.Context(), ClientIP(r)), n)
+}
+
+// Take n tokens from every bucket of the client, the first bucket without enough of them stops the request
+// and the tokens already taken from the other buckets are put back. The failure of the store is only logged
+// and the request is let through
+func take(ctx context.Context, store ratelimit.Store, keys []string, n int) (bool, time.Duration, error) {
+	var taken []string
+	for _, key := range keys {
+		allowed, retryAfter, err := store.Take(ctx, key, n)
+		if errors.Is(err, ratelimit.ErrExceedsBurst) {
+			refund(ctx, store, taken, n)
+			return false, 0, err
+		} else if err != nil {
+			logger.Log.Info("Couldn't check the rate limit", zap.Error(err))
+		} else if !allowed {
+			refund(ctx, store, taken, n)
+			metrics.RequestsRateLimited.Inc()
+			return false, retryAfter, nil
+		} else {
+			taken = append(taken, key)
+		}
+	}
+
+	return true, 0, nil
+}
+
+// Put back the tokens of the rejected request, otherwise the client would pay for the request it never made
+func refund(ctx context.Context, store ratelimit.Store, keys []string, n int) {
+	for _, key := range keys {
+		if err := store.Refund(ctx, key, n); err != nil {
+			logger.Log.Info("Couldn't refund the rate limit tokens", zap.Error(err))
+		}
+	}
+}
+
+// The IP is always limited, since anyone gets a new signed user ID just by coming without the cookie.
+// The bucket of the known user is the extra limit of the user who switches between the IPs
+func rateLimitKeys(ctx context.Context, ip net.IP) []string {
+	keys := []string{"ip:" + ip.String()}
+	if IsKnownUser(ctx) {
+		keys = append(keys, "user:"+UserIDFromContext(ctx))
+	}
+
+	return keys
+}
@@ -0,0 +1,39 @@
+package urls
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"go.uber.org/zap"
+)
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// Serve the OpenAPI document of the HTTP API. The server in the document is the public base URL from config
+func openAPIHandler(config conf.Configuration) (http.HandlerFunc, error) {
+	var spec map[string]any
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return nil, err
+	}
+	spec["servers"] = []map[string]string{
+		{"url": strings.TrimSuffix(config.ShortURL(""), "/")},
+	}
+
+	body, err := json.MarshalIndent(spec, "", "	")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(body); err != nil {
+			logger.Log.Info("Couldn't send the OpenAPI document", zap.String("error", err.Error()))
+		}
+	}, nil
+}
@@ -0,0 +1,153 @@
+package urls
+
+import (
+	"context"
+	"time"
+
+	"github.com/nomardt/urlshortener-x/internal/app/urls/handlers"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	"github.com/nomardt/urlshortener-x/internal/infra/metrics"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+)
+
+// The decorator observes the latency of every call to the wrapped Repository
+type instrumentedRepository struct {
+	repo handlers.Repository
+}
+
+func WithMetrics(repo handlers.Repository) handlers.Repository {
+	return &instrumentedRepository{repo: repo}
+}
+
+func observe(method string, start time.Time, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	metrics.RepositoryCallDuration.WithLabelValues(method, outcome).Observe(time.Since(start).Seconds())
+}
+
+func (r *instrumentedRepository) SaveURL(ctx context.Context, url *urlsDomain.URL) error {
+	start := time.Now()
+	err := r.repo.SaveURL(ctx, url)
+	observe("SaveURL", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) SaveURLs(ctx context.Context, urls []*urlsDomain.URL) ([]string, error) {
+	start := time.Now()
+	keys, err := r.repo.SaveURLs(ctx, urls)
+	observe("SaveURLs", start, err)
+	return keys, err
+}
+
+func (r *instrumentedRepository) GetURL(ctx context.Context, key string) (string, error) {
+	start := time.Now()
+	url, err := r.repo.GetURL(ctx, key)
+	observe("GetURL", start, err)
+	return url, err
+}
+
+func (r *instrumentedRepository) GetURLsByUser(ctx context.Context, userID string) ([]*urlsDomain.URL, error) {
+	start := time.Now()
+	urls, err := r.repo.GetURLsByUser(ctx, userID)
+	observe("GetURLsByUser", start, err)
+	return urls, err
+}
+
+func (r *instrumentedRepository) DeleteURLs(ctx context.Context, requests []urlsInfra.DeleteRequest) error {
+	start := time.Now()
+	err := r.repo.DeleteURLs(ctx, requests)
+	observe("DeleteURLs", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) DeleteExpiredURLs(ctx context.Context) (int, error) {
+	start := time.Now()
+	removed, err := r.repo.DeleteExpiredURLs(ctx)
+	observe("DeleteExpiredURLs", start, err)
+	return removed, err
+}
+
+func (r *instrumentedRepository) SaveClicks(ctx context.Context, clicks []urlsInfra.Click) error {
+	start := time.Now()
+	err := r.repo.SaveClicks(ctx, clicks)
+	observe("SaveClicks", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) GetURLStats(ctx context.Context, key string, userID string) (*urlsInfra.URLStats, error) {
+	start := time.Now()
+	stats, err := r.repo.GetURLStats(ctx, key, userID)
+	observe("GetURLStats", start, err)
+	return stats, err
+}
+
+func (r *instrumentedRepository) UpdateURL(ctx context.Context, key string, userID string, originalURL string) (*urlsInfra.URLVersion, error) {
+	start := time.Now()
+	version, err := r.repo.UpdateURL(ctx, key, userID, originalURL)
+	observe("UpdateURL", start, err)
+	return version, err
+}
+
+func (r *instrumentedRepository) RollbackURL(ctx context.Context, key string, userID string, version int) (*urlsInfra.URLVersion, error) {
+	start := time.Now()
+	urlVersion, err := r.repo.RollbackURL(ctx, key, userID, version)
+	observe("RollbackURL", start, err)
+	return urlVersion, err
+}
+
+func (r *instrumentedRepository) GetURLVersions(ctx context.Context, key string, userID string) ([]urlsInfra.URLVersion, error) {
+	start := time.Now()
+	versions, err := r.repo.GetURLVersions(ctx, key, userID)
+	observe("GetURLVersions", start, err)
+	return versions, err
+}
+
+func (r *instrumentedRepository) GetServiceStats(ctx context.Context) (*urlsInfra.ServiceStats, error) {
+	start := time.Now()
+	stats, err := r.repo.GetServiceStats(ctx)
+	observe("GetServiceStats", start, err)
+	return stats, err
+}
+
+func (r *instrumentedRepository) CountKeys(ctx context.Context) (int, error) {
+	start := time.Now()
+	count, err := r.repo.CountKeys(ctx)
+	observe("CountKeys", start, err)
+	return count, err
+}
+
+func (r *instrumentedRepository) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := r.repo.Ping(ctx)
+	observe("Ping", start, err)
+	return err
+}
+
+func (r *instrumentedRepository) ScanStoredURLs(ctx context.Context, after string, batchSize int, fn func([]urlsInfra.StoredURL) error) error {
+	return observeScan("ScanStoredURLs", fn, func(fn func([]urlsInfra.StoredURL) error) error {
+		return r.repo.ScanStoredURLs(ctx, after, batchSize, fn)
+	})
+}
+
+func (r *instrumentedRepository) ScanUserURLs(ctx context.Context, userID string, after string, batchSize int, fn func([]urlsInfra.StoredURL) error) error {
+	return observeScan("ScanUserURLs", fn, func(fn func([]urlsInfra.StoredURL) error) error {
+		return r.repo.ScanUserURLs(ctx, userID, after, batchSize, fn)
+	})
+}
+
+// The time spent in fn, e.g. writing the response, isn't the latency of the Repository and isn't observed
+func observeScan(method string, fn func([]urlsInfra.StoredURL) error, scan func(func([]urlsInfra.StoredURL) error) error) error {
+	start := time.Now()
+	var inFn time.Duration
+	err := scan(func(urls []urlsInfra.StoredURL) error {
+		fnStart := time.Now()
+		defer func() {
+			inFn += time.Since(fnStart)
+		}()
+		return fn(urls)
+	})
+	observe(method, start.Add(inFn), err)
+	return err
+}
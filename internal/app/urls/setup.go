@@ -5,15 +5,85 @@ import (
 	conf "github.com/nomardt/urlshortener-x/cmd/config"
 	"github.com/nomardt/urlshortener-x/internal/app/urls/handlers"
 	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
 	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/ratelimit"
+	"go.uber.org/zap"
 )
 
-func Setup(router *chi.Mux, urlsRepo handlers.Repository, config conf.Configuration) {
-	handler := handlers.NewHandler(urlsRepo, config)
+type setupOptions struct {
+	rateLimitStore ratelimit.Store
+	handlerOpts    []handlers.Option
+}
+
+type Option func(o *setupOptions)
+
+// Keep the rate limits in the store instead of the memory of the process
+func WithRateLimitStore(store ratelimit.Store) Option {
+	return func(o *setupOptions) {
+		o.rateLimitStore = store
+	}
+}
+
+// Generate the keys of the URLs with the specified generator
+func WithKeyGenerator(keys urlsDomain.KeyGenerator) Option {
+	return func(o *setupOptions) {
+		o.handlerOpts = append(o.handlerOpts, handlers.WithKeyGenerator(keys))
+	}
+}
+
+// Register the routes of the shortener. The returned handler must be closed once the server is stopped
+func Setup(router *chi.Mux, urlsRepo handlers.Repository, config conf.Configuration, opts ...Option) *handlers.Handler {
+	var options setupOptions
+	if config.RateLimit > 0 {
+		options.rateLimitStore = ratelimit.NewMemoryStore(ratelimit.PerMinute(config.RateLimit, config.RateBurst))
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	handlerOpts := append([]handlers.Option{handlers.WithRateLimitStore(options.rateLimitStore)}, options.handlerOpts...)
+	handler := handlers.NewHandler(urlsRepo, config, handlerOpts...)
+	limitSingle := middlewares.RateLimit(options.rateLimitStore, middlewares.SingleCost)
+	limitBatch := middlewares.RateLimit(options.rateLimitStore, middlewares.BatchCost)
+
+	routes := func(r chi.Router) {
+		r.Use(middlewares.WithClientIP(config.TrustedProxyNetworks()))
+		r.Use(middlewares.WithAuth(config.SecretKey))
+
+		r.Post("/", logger.WithLogging(limitSingle(middlewares.OnlyPlaintextBody(handler.PostURI))))
+		r.Get("/{id}", logger.WithLogging(handler.GetURI))
+
+		r.Post("/api/shorten", logger.WithLogging(limitSingle(middlewares.OnlyJSONBody(handler.JSONPostURI))))
+		// The batch is counted once it is decompressed
+		r.Post("/api/shorten/batch", logger.WithLogging(middlewares.OnlyJSONBody(limitBatch(handler.JSONPostBatch))))
+
+		r.Get("/api/user/urls", logger.WithLogging(handler.GetUserURIs))
+		r.Delete("/api/user/urls", logger.WithLogging(middlewares.OnlyJSONBody(handler.DeleteUserURIs)))
+
+		r.Get("/api/export", logger.WithLogging(handler.ExportURIs))
+		// The import is charged row by row as it is read
+		r.Post("/api/import", logger.WithLogging(handler.ImportURIs))
+
+		r.Get("/api/urls/{key}/stats", logger.WithLogging(handler.GetURIStats))
+		r.Patch("/api/urls/{key}", logger.WithLogging(limitSingle(middlewares.OnlyJSONBody(handler.PatchURI))))
+		r.Get("/api/urls/{key}/history", logger.WithLogging(handler.GetURIHistory))
+		r.Post("/api/urls/{key}/rollback", logger.WithLogging(limitSingle(middlewares.OnlyJSONBody(handler.RollbackURI))))
+
+		r.Get("/api/internal/stats", logger.WithLogging(middlewares.OnlyTrustedSubnet(config.TrustedNetwork())(handler.GetInternalStats)))
+	}
+
+	if spec, err := openAPIHandler(config); err != nil {
+		logger.Log.Info("The OpenAPI document is broken and won't be served", zap.Error(err))
+	} else {
+		router.Get("/openapi.json", logger.WithLogging(spec))
+	}
 
-	router.Post("/", logger.WithLogging(middlewares.OnlyPlaintextBody(handler.PostURI)))
-	router.Get("/{id}", logger.WithLogging(handler.GetURI))
+	// The routes are served under the path of the public base URL, e.g. /links/{id}
+	if basePath := config.BasePath(); basePath != "" {
+		router.Route(basePath, routes)
+	} else {
+		router.Group(routes)
+	}
 
-	router.Post("/api/shorten", logger.WithLogging(middlewares.OnlyJSONBody(handler.JSONPostURI)))
-	router.Post("/api/shorten/batch", logger.WithLogging(middlewares.OnlyJSONBody(handler.JSONPostBatch)))
+	return handler
 }
@@ -0,0 +1,35 @@
+package urls
+
+import (
+	"google.golang.org/grpc"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/internal/app/urls/handlers"
+	"github.com/nomardt/urlshortener-x/internal/app/urls/middlewares"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	pb "github.com/nomardt/urlshortener-x/pkg/shortenerpb"
+)
+
+// Create the gRPC server of the shortener. It shares the handler, and so the background workers, with the HTTP routes
+func SetupGRPC(handler *handlers.Handler, config conf.Configuration) *grpc.Server {
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		logger.UnaryLoggingInterceptor,
+		middlewares.AuthInterceptor(config.SecretKey),
+		middlewares.RateLimitInterceptor(handler.RateLimitStore(), grpcRequestCost),
+	))
+	pb.RegisterShortenerServer(server, handlers.NewGRPCServer(handler))
+
+	return server
+}
+
+// Only the shortening is limited, every URL of the batch costs a token the same way it does over HTTP
+func grpcRequestCost(req any) int {
+	switch req := req.(type) {
+	case *pb.ShortenRequest:
+		return 1
+	case *pb.ShortenBatchRequest:
+		return max(len(req.GetUrls()), 1)
+	default:
+		return 0
+	}
+}
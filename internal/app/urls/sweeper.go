@@ -0,0 +1,61 @@
+package urls
+
+import (
+	"context"
+	"time"
+
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/metrics"
+	"go.uber.org/zap"
+)
+
+type expiredURLsDeleter interface {
+	DeleteExpiredURLs(ctx context.Context) (int, error)
+}
+
+// Periodically remove expired URLs from the repo until the context is canceled
+func RunSweeper(ctx context.Context, repo expiredURLsDeleter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			removed, err := repo.DeleteExpiredURLs(ctx)
+			if err != nil {
+				logger.Log.Info("Couldn't remove expired URLs", zap.Error(err))
+			} else if removed > 0 {
+				logger.Log.Info("Removed expired URLs", zap.Int("count", removed))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type keyCounter interface {
+	CountKeys(ctx context.Context) (int, error)
+}
+
+// Periodically report how much of the key space the stored links take until the context is canceled.
+// The deleted and the expired links are counted too, their keys aren't free until they are swept
+func RunKeySpaceMeter(ctx context.Context, repo keyCounter, keys urlsDomain.KeyGenerator, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		count, err := repo.CountKeys(ctx)
+		if err != nil {
+			logger.Log.Info("Couldn't count the keys for the key space usage", zap.Error(err))
+		} else {
+			metrics.KeySpaceUsage.Set(float64(count) / keys.KeySpace())
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package urls
+
+import (
+	"crypto/rand"
+	"errors"
+	"math"
+	"math/big"
+	"math/bits"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// Random keys which don't reveal how many links there are. The only strategy that suits several instances
+	KeyGeneratorRandom = "random"
+	// The counter written in the alphabet: the shortest keys, but the next key is easy to guess
+	KeyGeneratorSequential = "sequential"
+	// The counter scrambled with a permutation of the key space, the keys look random but never collide
+	KeyGeneratorObfuscated = "obfuscated"
+
+	DefaultKeyLength   = 8
+	DefaultKeyAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+var (
+	ErrInvalidKeyGenerator = errors.New("the key generator must be random, sequential or obfuscated")
+	ErrInvalidKeyLength    = errors.New("the key length must be between 1 and 100")
+	ErrInvalidKeyAlphabet  = errors.New("the key alphabet must have at least 2 distinct characters which are allowed in the keys")
+	ErrKeySpaceTooLarge    = errors.New("the counter can't address that many keys, please make the keys or the alphabet shorter")
+	ErrKeySpaceExhausted   = errors.New("every key of the key space has been generated")
+)
+
+// Generates the keys of the URLs shortened without an alias. The key may already be taken,
+// so the caller retries with the next one
+type KeyGenerator interface {
+	NextKey() (string, error)
+	// The number of the distinct keys the generator produces
+	KeySpace() float64
+}
+
+// The generator which counts the keys. It starts from zero, so after a restart it has to be moved past
+// the keys which are already stored
+type CountingKeyGenerator interface {
+	KeyGenerator
+	StartAt(n uint64)
+	// The value of the counter the key is generated from, false if the generator never produces the key
+	Index(key string) (uint64, bool)
+}
+
+var defaultKeyGenerator, _ = NewKeyGenerator(KeyGeneratorRandom, DefaultKeyLength, DefaultKeyAlphabet)
+
+// Create the generator of the strategy. The empty strategy, the zero length and the empty alphabet are the defaults
+func NewKeyGenerator(strategy string, length int, alphabet string) (KeyGenerator, error) {
+	if length == 0 {
+		length = DefaultKeyLength
+	}
+	if alphabet == "" {
+		alphabet = DefaultKeyAlphabet
+	}
+	if length < 0 || length > maxKeyLength {
+		return nil, ErrInvalidKeyLength
+	}
+	if err := validateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+
+	switch strategy {
+	case "", KeyGeneratorRandom:
+		return &randomKeyGenerator{alphabet: alphabet, length: length}, nil
+	case KeyGeneratorSequential, KeyGeneratorObfuscated:
+		space, ok := keySpace(len(alphabet), length)
+		if !ok {
+			return nil, ErrKeySpaceTooLarge
+		}
+		g := &counterKeyGenerator{alphabet: alphabet, length: length, space: space}
+		if strategy == KeyGeneratorObfuscated {
+			g.multiplier, g.offset = permutation(space)
+			g.inverse = modInverse(g.multiplier, space)
+		} else {
+			g.multiplier, g.inverse = 1, 1
+		}
+		return g, nil
+	default:
+		return nil, ErrInvalidKeyGenerator
+	}
+}
+
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 || ValidateKey(alphabet) != nil {
+		return ErrInvalidKeyAlphabet
+	}
+	for i := range alphabet {
+		if strings.IndexByte(alphabet[i+1:], alphabet[i]) >= 0 {
+			return ErrInvalidKeyAlphabet
+		}
+	}
+	return nil
+}
+
+// Count the keys of the length, false is returned if they don't fit in uint64
+func keySpace(base int, length int) (uint64, bool) {
+	space := uint64(1)
+	for i := 0; i < length; i++ {
+		hi, lo := bits.Mul64(space, uint64(base))
+		if hi != 0 {
+			return 0, false
+		}
+		space = lo
+	}
+	return space, true
+}
+
+type randomKeyGenerator struct {
+	alphabet string
+	length   int
+}
+
+// The bytes which would make some characters more likely than the others are thrown away
+func (g *randomKeyGenerator) NextKey() (string, error) {
+	limit := 256 - 256%len(g.alphabet)
+	key := make([]byte, 0, g.length)
+	buf := make([]byte, g.length*2)
+	for len(key) < g.length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(key) < g.length {
+				key = append(key, g.alphabet[int(b)%len(g.alphabet)])
+			}
+		}
+	}
+	return string(key), nil
+}
+
+func (g *randomKeyGenerator) KeySpace() float64 {
+	return math.Pow(float64(len(g.alphabet)), float64(g.length))
+}
+
+// The counter mapped onto the key space with n*multiplier+offset mod space. The multiplier is coprime
+// with the space, so the mapping is a permutation and the keys never repeat
+type counterKeyGenerator struct {
+	alphabet   string
+	length     int
+	space      uint64
+	multiplier uint64
+	// The inverse of the multiplier modulo the space, it maps the keys back to the counter
+	inverse uint64
+	offset  uint64
+	next    atomic.Uint64
+}
+
+func (g *counterKeyGenerator) NextKey() (string, error) {
+	n := g.next.Add(1) - 1
+	if n >= g.space {
+		return "", ErrKeySpaceExhausted
+	}
+
+	return g.encode(g.permute(n)), nil
+}
+
+func (g *counterKeyGenerator) KeySpace() float64 {
+	return float64(g.space)
+}
+
+func (g *counterKeyGenerator) StartAt(n uint64) {
+	g.next.Store(n)
+}
+
+func (g *counterKeyGenerator) Index(key string) (uint64, bool) {
+	n, ok := g.decode(key)
+	if !ok {
+		return 0, false
+	}
+
+	return g.unpermute(n), true
+}
+
+func (g *counterKeyGenerator) permute(n uint64) uint64 {
+	// Both factors are less than the space, so the high half of the product is too and Div64 doesn't panic
+	hi, lo := bits.Mul64(n, g.multiplier)
+	_, n = bits.Div64(hi, lo, g.space)
+	if n >= g.space-g.offset {
+		return n - (g.space - g.offset)
+	}
+	return n + g.offset
+}
+
+func (g *counterKeyGenerator) unpermute(n uint64) uint64 {
+	if n >= g.offset {
+		n -= g.offset
+	} else {
+		n += g.space - g.offset
+	}
+	hi, lo := bits.Mul64(n, g.inverse)
+	_, n = bits.Div64(hi, lo, g.space)
+	return n
+}
+
+// Write the number in the alphabet, padded to the length with the first character
+func (g *counterKeyGenerator) encode(n uint64) string {
+	base := uint64(len(g.alphabet))
+	key := make([]byte, g.length)
+	for i := g.length - 1; i >= 0; i-- {
+		key[i] = g.alphabet[n%base]
+		n /= base
+	}
+	return string(key)
+}
+
+// Read the number written in the alphabet. The key of the length can't overflow, the space fits in uint64
+func (g *counterKeyGenerator) decode(key string) (uint64, bool) {
+	if len(key) != g.length {
+		return 0, false
+	}
+
+	base := uint64(len(g.alphabet))
+	var n uint64
+	for i := 0; i < len(key); i++ {
+		digit := strings.IndexByte(g.alphabet, key[i])
+		if digit < 0 {
+			return 0, false
+		}
+		n = n*base + uint64(digit)
+	}
+	return n, true
+}
+
+// Pick the multiplier near the golden ratio of the space, so that the consecutive keys land far apart
+func permutation(space uint64) (multiplier uint64, offset uint64) {
+	multiplier = uint64(float64(space) * 0.6180339887498949)
+	if multiplier == 0 {
+		multiplier = 1
+	}
+	for gcd(multiplier, space) != 1 {
+		multiplier++
+	}
+	return multiplier % space, space / 3
+}
+
+// The multiplier is coprime with the space, so the inverse exists
+func modInverse(a uint64, m uint64) uint64 {
+	return new(big.Int).ModInverse(new(big.Int).SetUint64(a), new(big.Int).SetUint64(m)).Uint64()
+}
+
+func gcd(a uint64, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
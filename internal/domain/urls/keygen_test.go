@@ -0,0 +1,111 @@
+package urls
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_NewKeyGenerator(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		length   int
+		alphabet string
+		wantErr  error
+	}{
+		{name: "Defaults"},
+		{name: "Sequential", strategy: KeyGeneratorSequential, length: 4, alphabet: "abc"},
+		{name: "Unknown strategy", strategy: "uuid", wantErr: ErrInvalidKeyGenerator},
+		{name: "Too long", length: 101, wantErr: ErrInvalidKeyLength},
+		{name: "Repeated characters", alphabet: "abca", wantErr: ErrInvalidKeyAlphabet},
+		{name: "Forbidden characters", alphabet: "ab/", wantErr: ErrInvalidKeyAlphabet},
+		{name: "Single character", alphabet: "a", wantErr: ErrInvalidKeyAlphabet},
+		{name: "The counter overflows", strategy: KeyGeneratorObfuscated, length: 11, wantErr: ErrKeySpaceTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyGenerator(tt.strategy, tt.length, tt.alphabet)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func Test_RandomKeyGenerator(t *testing.T) {
+	keys, err := NewKeyGenerator(KeyGeneratorRandom, 12, "")
+	require.NoError(t, err)
+
+	// Concurrent calls don't share a seed, so they don't get the same keys
+	var mu sync.Mutex
+	seen := make(map[string]struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key, err := keys.NextKey()
+				require.NoError(t, err)
+				require.NoError(t, ValidateKey(key))
+				assert.Len(t, key, 12)
+
+				mu.Lock()
+				seen[key] = struct{}{}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, seen, 800)
+}
+
+func Test_CounterKeyGenerators(t *testing.T) {
+	// Test case: The sequential keys are the counter written in the alphabet
+	keys, err := NewKeyGenerator(KeyGeneratorSequential, 3, "01")
+	require.NoError(t, err)
+	var got []string
+	for i := 0; i < 4; i++ {
+		key, err := keys.NextKey()
+		require.NoError(t, err)
+		got = append(got, key)
+	}
+	assert.Equal(t, []string{"000", "001", "010", "011"}, got)
+
+	// Test case: The counter continues from the stored keys
+	keys.(CountingKeyGenerator).StartAt(7)
+	key, err := keys.NextKey()
+	require.NoError(t, err)
+	assert.Equal(t, "111", key)
+	_, err = keys.NextKey()
+	assert.ErrorIs(t, err, ErrKeySpaceExhausted)
+
+	// Test case: The obfuscated keys cover the whole key space without repeating
+	keys, err = NewKeyGenerator(KeyGeneratorObfuscated, 3, "abcde")
+	require.NoError(t, err)
+	assert.Equal(t, float64(125), keys.KeySpace())
+	seen := make(map[string]struct{})
+	var first []string
+	for i := 0; i < 125; i++ {
+		key, err := keys.NextKey()
+		require.NoError(t, err)
+		seen[key] = struct{}{}
+		if i < 3 {
+			first = append(first, key)
+		}
+
+		// The key maps back to the value of the counter
+		n, ok := keys.(CountingKeyGenerator).Index(key)
+		require.True(t, ok)
+		assert.Equal(t, uint64(i), n)
+	}
+	assert.Len(t, seen, 125)
+	assert.NotEqual(t, []string{"aaa", "aab", "aac"}, first)
+
+	// Test case: The keys the generator never produces
+	for _, key := range []string{"aaaa", "aaz", ""} {
+		_, ok := keys.(CountingKeyGenerator).Index(key)
+		assert.False(t, ok, key)
+	}
+}
@@ -2,8 +2,8 @@ package urls
 
 import (
 	"errors"
-	"math/rand"
 	"net/url"
+	"regexp"
 	"time"
 )
 
@@ -11,21 +11,43 @@ type URL struct {
 	correlationID string
 	id            string
 	longURL       string
+	userID        string
+	expiresAt     time.Time
 }
 
+const maxKeyLength = 100
+
 var (
 	ErrInvalidURL = errors.New("please enter a valid URL")
+	ErrInvalidKey = errors.New("please specify a valid key! It can only contain alphanumeric characters, '.', '_', '~' and '-'")
+
+	invalidKeyChars = regexp.MustCompile("[^A-Za-z0-9_.~-]+")
 )
 
-// Creates a new URL object with the URL provided
-func NewURLWithoutKey(longURL string, correlationID string) (*URL, error) {
-	id := generateRandomID(8)
-	return NewURL(longURL, id, correlationID)
+// Creates a new URL object with the URL provided and a random key
+func NewURLWithoutKey(longURL string, correlationID string, userID string) (*URL, error) {
+	return NewURLWithGeneratedKey(defaultKeyGenerator, longURL, correlationID, userID)
+}
+
+// Create a new URL object with the next key of the generator
+func NewURLWithGeneratedKey(keys KeyGenerator, longURL string, correlationID string, userID string) (*URL, error) {
+	if err := ValidateURL(longURL); err != nil {
+		return nil, err
+	}
+
+	key, err := keys.NextKey()
+	if err != nil {
+		return nil, err
+	}
+	return NewURL(longURL, key, correlationID, userID)
 }
 
 // Create a new URL object when you know which ID to use
-func NewURL(longURL string, key string, correlationID string) (*URL, error) {
-	if err := validateURL(longURL); err != nil {
+func NewURL(longURL string, key string, correlationID string, userID string) (*URL, error) {
+	if err := ValidateURL(longURL); err != nil {
+		return nil, err
+	}
+	if err := ValidateKey(key); err != nil {
 		return nil, err
 	}
 
@@ -33,6 +55,7 @@ func NewURL(longURL string, key string, correlationID string) (*URL, error) {
 		correlationID: correlationID,
 		id:            key,
 		longURL:       longURL,
+		userID:        userID,
 	}, nil
 }
 
@@ -48,7 +71,35 @@ func (u *URL) CorrelationID() string {
 	return u.correlationID
 }
 
-func validateURL(rawURL string) error {
+// Set the moment after which the URL stops working. The zero time means the URL never expires
+func (u *URL) SetExpiresAt(expiresAt time.Time) {
+	u.expiresAt = expiresAt
+}
+
+func (u *URL) ExpiresAt() time.Time {
+	return u.expiresAt
+}
+
+// Check whether the URL has expired by the specified moment
+func (u *URL) IsExpired(now time.Time) bool {
+	return !u.expiresAt.IsZero() && !now.Before(u.expiresAt)
+}
+
+// Returns the ID of the user who shortened the URL
+func (u *URL) UserID() string {
+	return u.userID
+}
+
+// Check that the key can be used as the path of a shortened URL
+func ValidateKey(key string) error {
+	if key == "" || len(key) > maxKeyLength || invalidKeyChars.MatchString(key) {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+// Check that the URL can be shortened, only the absolute http and https URLs can
+func ValidateURL(rawURL string) error {
 	u, err := url.ParseRequestURI(rawURL)
 	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || string(u.Host[0]) == "." || string(u.Host[len(u.Host)-1]) == "." {
 		return ErrInvalidURL
@@ -56,14 +107,3 @@ func validateURL(rawURL string) error {
 		return nil
 	}
 }
-
-func generateRandomID(n int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	seededRand := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
-	}
-	return string(b)
-}
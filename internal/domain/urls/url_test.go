@@ -28,10 +28,16 @@ func Test_NewURL(t *testing.T) {
 			want:    nil,
 			wantErr: true,
 		},
+		{
+			name:    "Invalid key",
+			args:    args{longURL: "https://example.com/?abc", id: "a/b"},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewURL(tt.args.longURL, tt.args.id, tt.args.id)
+			got, err := NewURL(tt.args.longURL, tt.args.id, tt.args.id, "")
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewURL() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/internal/infra/certs"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+)
+
+const certReloadInterval = 10 * time.Second
+
+// Build the TLS config of the HTTPS server. The certificate files are watched until the context is done
+func newTLSConfig(ctx context.Context, config conf.Configuration) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.TLSCertFile == "" {
+		logger.Log.Info("The certificate files aren't specified, serving HTTPS with a self-signed certificate")
+		cert, err := certs.SelfSigned(certHosts(config))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+		return tlsConfig, nil
+	}
+
+	reloader, err := certs.NewReloader(config.TLSCertFile, config.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	go reloader.Watch(ctx, certReloadInterval)
+	tlsConfig.GetCertificate = reloader.GetCertificate
+
+	return tlsConfig, nil
+}
+
+// The self-signed certificate is valid for the local hosts, the listen address and the host of the base URL
+func certHosts(config conf.Configuration) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(config.ListenAddress); err == nil {
+		hosts = append(hosts, host)
+	}
+	if u, err := url.Parse(config.BaseURL); err == nil && u.Hostname() != "" {
+		hosts = append(hosts, u.Hostname())
+	}
+
+	return hosts
+}
+
+// Redirect every request to the same URI of the HTTPS server. The public base URL wins over the host
+// of the request if it is an HTTPS one
+func redirectToHTTPS(config conf.Configuration) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(config.ListenAddress)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var origin string
+		if strings.HasPrefix(config.BaseURL, "https://") {
+			u, _ := url.Parse(config.BaseURL)
+			origin = "https://" + u.Host
+		} else {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = h
+			}
+			if httpsPort != "443" {
+				host = net.JoinHostPort(host, httpsPort)
+			}
+			origin = "https://" + host
+		}
+
+		// 308 keeps the method and the body of the shortening requests
+		http.Redirect(w, r, origin+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
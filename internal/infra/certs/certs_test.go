@@ -0,0 +1,80 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SelfSigned(t *testing.T) {
+	cert, err := SelfSigned([]string{"localhost", "127.0.0.1", "example.com"})
+	require.NoError(t, err)
+
+	assert.NoError(t, cert.Leaf.VerifyHostname("localhost"))
+	assert.NoError(t, cert.Leaf.VerifyHostname("127.0.0.1"))
+	assert.NoError(t, cert.Leaf.VerifyHostname("example.com"))
+	assert.Error(t, cert.Leaf.VerifyHostname("example.org"))
+}
+
+// Write the certificate and its key as the PEM files
+func writeCert(t *testing.T, certFile string, keyFile string, cert tls.Certificate, modTime time.Time) {
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func Test_Reloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	first, err := SelfSigned([]string{"localhost"})
+	require.NoError(t, err)
+	writeCert(t, certFile, keyFile, first, time.Now().Add(-time.Minute))
+
+	r, err := NewReloader(certFile, keyFile)
+	require.NoError(t, err)
+	cert, err := r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, first.Certificate[0], cert.Certificate[0])
+
+	// Test case: The files haven't changed
+	reloaded, err := r.reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	// Test case: The certificate has been renewed
+	second, err := SelfSigned([]string{"localhost"})
+	require.NoError(t, err)
+	writeCert(t, certFile, keyFile, second, time.Now())
+
+	reloaded, err = r.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.Certificate[0], cert.Certificate[0])
+
+	// Test case: The broken files don't replace the certificate
+	require.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0o600))
+	_, err = r.reload()
+	assert.Error(t, err)
+	cert, err = r.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.Certificate[0], cert.Certificate[0])
+
+	// Test case: The missing files
+	_, err = NewReloader(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.Error(t, err)
+}
@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// The gRPC counterpart of WithLogging
+func UnaryLoggingInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+
+	resp, err := handler(ctx, req)
+
+	duration := time.Since(start)
+
+	var addr string
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr.String()
+	}
+
+	Log.Info("Handled gRPC request",
+		zap.String("method", info.FullMethod),
+		zap.String("duration", duration.String()),
+		zap.String("code", status.Code(err).String()),
+		zap.String("IP", addr),
+		zap.String("date", time.Now().Format("2006/01/02")),
+		zap.String("time", time.Now().Format("15:04:05")),
+	)
+
+	return resp, err
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func sampleCount(t *testing.T, labels ...string) uint64 {
+	t.Helper()
+
+	metric := &dto.Metric{}
+	if err := RequestDuration.WithLabelValues(labels...).(prometheus.Histogram).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func Test_WithMetrics(t *testing.T) {
+	router := chi.NewRouter()
+	router.Use(WithMetrics)
+	router.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+	})
+	router.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("created")) //nolint:all
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		labels []string
+	}{
+		{
+			name:   "The route pattern is used instead of the path",
+			method: http.MethodGet,
+			path:   "/abc",
+			labels: []string{http.MethodGet, "/{id}", "307"},
+		},
+		{
+			name:   "Implicit status",
+			method: http.MethodPost,
+			path:   "/",
+			labels: []string{http.MethodPost, "/", "200"},
+		},
+		{
+			name:   "Unmatched route",
+			method: http.MethodGet,
+			path:   "/a/b/c",
+			labels: []string{http.MethodGet, "unmatched", "404"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := sampleCount(t, tc.labels...)
+
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+
+			if after := sampleCount(t, tc.labels...); after != before+1 {
+				t.Errorf("Expected %d observations, got %d", before+1, after)
+			}
+		})
+	}
+}
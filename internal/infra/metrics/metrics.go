@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "shortener"
+
+var (
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "The duration of the HTTP requests by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	RepositoryCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repository_call_duration_seconds",
+		Help:      "The duration of the repository calls by method and outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "outcome"})
+
+	LinksCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_created_total",
+		Help:      "The number of shortened links.",
+	})
+	LinkConflicts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "link_conflicts_total",
+		Help:      "The number of links which weren't shortened because the URL or the key was already taken.",
+	}, []string{"reason"})
+	KeyCollisions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "key_collisions_total",
+		Help:      "The number of generated keys which were already taken and were generated again.",
+	})
+	KeySpaceUsage = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "key_space_usage_ratio",
+		Help:      "The share of the key space of the key generator taken by the stored links.",
+	})
+	LinksNotFound = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "links_not_found_total",
+		Help:      "The number of redirects to the links which don't exist.",
+	})
+
+	ClicksRecorded = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "clicks_recorded_total",
+		Help:      "The number of click events written to the storage.",
+	})
+	ClicksDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "clicks_dropped_total",
+		Help:      "The number of click events dropped because the queue was full or the storage failed.",
+	}, []string{"reason"})
+
+	RequestsRateLimited = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_rate_limited_total",
+		Help:      "The number of requests rejected because the client exceeded the rate limit.",
+	})
+)
@@ -0,0 +1,314 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+)
+
+// Every migration is a pair of files NNNN_name.up.sql and NNNN_name.down.sql
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+// The applied migrations are recorded in this table
+const trackingTable = "schema_migrations"
+
+// The advisory lock keeps several instances of the server from migrating the database at the same time
+const advisoryLockID = 4733190264
+
+// How long the migrator waits for another instance to finish migrating
+const defaultLockTimeout = time.Minute
+
+var (
+	ErrNoMigrationsApplied = errors.New("there are no applied migrations to roll back")
+	ErrLockTimeout         = errors.New("another instance is still migrating the database")
+)
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// The state of a migration in the database
+type Status struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db          *sql.DB
+	migrations  []migration
+	lockTimeout time.Duration
+}
+
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(files)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:          db,
+		migrations:  migrations,
+		lockTimeout: defaultLockTimeout,
+	}, nil
+}
+
+// Apply all the pending migrations in order. The versions of the applied migrations are returned
+func (m *Migrator) Up(ctx context.Context) ([]int, error) {
+	var applied []int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		if err := createTrackingTable(ctx, conn); err != nil {
+			return err
+		}
+		appliedAt, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, found := appliedAt[migration.version]; found {
+				continue
+			}
+
+			err := runInTx(ctx, conn, migration.up,
+				"INSERT INTO "+trackingTable+" (version, name, applied_at) VALUES ($1, $2, $3)",
+				migration.version, migration.name, time.Now())
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.version, migration.name, err)
+			}
+			logger.Log.Info("Applied the migration", zap.Int("version", migration.version), zap.String("name", migration.name))
+
+			applied = append(applied, migration.version)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Roll back the last applied migration. The version of the rolled back migration is returned
+func (m *Migrator) Down(ctx context.Context) (int, error) {
+	var version int
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		if err := createTrackingTable(ctx, conn); err != nil {
+			return err
+		}
+		appliedAt, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if _, found := appliedAt[migration.version]; !found {
+				continue
+			}
+
+			err := runInTx(ctx, conn, migration.down,
+				"DELETE FROM "+trackingTable+" WHERE version = $1",
+				migration.version)
+			if err != nil {
+				return fmt.Errorf("migration %04d_%s: %w", migration.version, migration.name, err)
+			}
+			logger.Log.Info("Rolled back the migration", zap.Int("version", migration.version), zap.String("name", migration.name))
+
+			version = migration.version
+			return nil
+		}
+
+		return ErrNoMigrationsApplied
+	})
+
+	return version, err
+}
+
+// Get the state of every known migration. The database is only read, so the status is shown
+// even while another instance is migrating it
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var tracked bool
+	if err := m.db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", trackingTable).Scan(&tracked); err != nil {
+		return nil, err
+	}
+
+	// Nothing is applied to the database which has no tracking table yet
+	appliedAt := make(map[int]time.Time)
+	if tracked {
+		var err error
+		if appliedAt, err = appliedVersions(ctx, m.db); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{
+			Version: migration.version,
+			Name:    migration.name,
+		}
+		if at, found := appliedAt[migration.version]; found {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// Advisory locks belong to a session, so everything is done on a single connection
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	lockCtx, cancel := context.WithTimeout(ctx, m.lockTimeout)
+	defer cancel()
+	if _, err := conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", advisoryLockID); err != nil {
+		if errors.Is(lockCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %w", ErrLockTimeout, err)
+		}
+		return err
+	}
+	defer func() {
+		// The lock is released with the session anyway if the unlock fails
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryLockID); err != nil {
+			logger.Log.Info("Couldn't release the migrations lock", zap.Error(err))
+		}
+	}()
+
+	return f(conn)
+}
+
+func createTrackingTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS `+trackingTable+` (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL
+		)
+	`)
+
+	return err
+}
+
+// The database or the connection the tracking table is read with
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func appliedVersions(ctx context.Context, db querier) (map[int]time.Time, error) {
+	rows, err := db.QueryContext(ctx, "SELECT version, applied_at FROM "+trackingTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedAt := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+
+	return appliedAt, rows.Err()
+}
+
+// The migration and its record in the tracking table are committed together
+func runInTx(ctx context.Context, conn *sql.Conn, migrationSQL string, trackingSQL string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	if _, err := tx.ExecContext(ctx, migrationSQL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, trackingSQL, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Read the migrations from the file system and sort them by version
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	names, err := fs.Glob(fsys, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, name := range names {
+		base := path.Base(name)
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s must end with .up.sql or .down.sql", base)
+		}
+
+		rawVersion, migrationName, found := strings.Cut(strings.TrimSuffix(base, "."+direction+".sql"), "_")
+		version, err := strconv.Atoi(rawVersion)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name.%s.sql", base, direction)
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+
+		m, exists := byVersion[version]
+		if !exists {
+			m = &migration{version: version, name: migrationName}
+			byVersion[version] = m
+		} else if m.name != migrationName {
+			return nil, fmt.Errorf("migration %04d has two names: %s and %s", version, m.name, migrationName)
+		}
+
+		if direction == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down files", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	return migrations, nil
+}
@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+func Test_LoadMigrations(t *testing.T) {
+	// Test case: The embedded migrations are complete and ordered
+	migrations, err := loadMigrations(files)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("Expected embedded migrations")
+	}
+	for i, migration := range migrations {
+		if migration.version != i+1 {
+			t.Errorf("Expected version %d, got %d", i+1, migration.version)
+		}
+		if migration.up == "" || migration.down == "" {
+			t.Errorf("Expected both up and down SQL for %04d_%s", migration.version, migration.name)
+		}
+	}
+
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "Missing down file",
+			fsys: fstest.MapFS{
+				"sql/0001_create.up.sql": {Data: []byte("SELECT 1")},
+			},
+		},
+		{
+			name: "Invalid version",
+			fsys: fstest.MapFS{
+				"sql/first_create.up.sql":   {Data: []byte("SELECT 1")},
+				"sql/first_create.down.sql": {Data: []byte("SELECT 1")},
+			},
+		},
+		{
+			name: "Different names for the same version",
+			fsys: fstest.MapFS{
+				"sql/0001_create.up.sql": {Data: []byte("SELECT 1")},
+				"sql/0001_drop.down.sql": {Data: []byte("SELECT 1")},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := loadMigrations(tc.fsys); err == nil {
+				t.Error("Expected an error, got nil")
+			}
+		})
+	}
+}
+
+// The migrations are run against the database of DATABASE_DSN in a schema of their own
+func Test_MigratorWithDatabase(t *testing.T) {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("DATABASE_DSN isn't set")
+	}
+	ctx := context.Background()
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatal(err)
+	}
+	defer admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE") //nolint:errcheck
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+	db, err := sql.Open("pgx", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	m, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracked := func() bool {
+		var found bool
+		if err := db.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", trackingTable).Scan(&found); err != nil {
+			t.Fatal(err)
+		}
+		return found
+	}
+
+	// Test case: The status of the new database changes nothing in it
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(statuses) != len(m.migrations) || statuses[0].AppliedAt != nil {
+		t.Errorf("Expected %d pending migrations, got %v", len(m.migrations), statuses)
+	}
+	if tracked() {
+		t.Error("Expected the status not to create the tracking table")
+	}
+
+	// Test case: Every migration is applied once
+	applied, err := m.Up(ctx)
+	if err != nil || len(applied) != len(m.migrations) {
+		t.Fatalf("Expected %d applied migrations, got %v and %v", len(m.migrations), applied, err)
+	}
+	if applied, err := m.Up(ctx); err != nil || len(applied) != 0 {
+		t.Errorf("Expected no applied migrations, got %v and %v", applied, err)
+	}
+
+	// Test case: The status is read while another instance holds the lock, the migration gives up waiting
+	conn, err := admin.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", advisoryLockID); err != nil {
+		t.Fatal(err)
+	}
+	statuses, err = m.Status(ctx)
+	if err != nil || statuses[len(statuses)-1].AppliedAt == nil {
+		t.Errorf("Expected every migration to be applied, got %v and %v", statuses, err)
+	}
+	m.lockTimeout = 100 * time.Millisecond
+	if _, err := m.Down(ctx); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("Expected ErrLockTimeout, got: %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", advisoryLockID); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	// Test case: The last migration is rolled back
+	if version, err := m.Down(ctx); err != nil || version != len(m.migrations) {
+		t.Errorf("Expected migration %d to be rolled back, got %d and %v", len(m.migrations), version, err)
+	}
+}
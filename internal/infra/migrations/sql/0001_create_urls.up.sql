@@ -0,0 +1,16 @@
+-- Tables created by the server before the migrations were introduced already exist,
+-- so they only get the missing columns
+CREATE TABLE IF NOT EXISTS urls (
+	id VARCHAR(255) PRIMARY KEY DEFAULT gen_random_uuid()::text,
+	key VARCHAR(100) UNIQUE,
+	full_uri VARCHAR(1500) UNIQUE,
+	user_id VARCHAR(255),
+	is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	expires_at TIMESTAMPTZ,
+	created_at TIMESTAMP,
+	updated_at TIMESTAMP
+);
+
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS user_id VARCHAR(255);
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE urls ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS urls_user_id_idx ON urls (user_id);
+CREATE INDEX IF NOT EXISTS urls_expires_at_idx ON urls (expires_at) WHERE expires_at IS NOT NULL;
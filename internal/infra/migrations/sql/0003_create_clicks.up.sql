@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS clicks (
+	id BIGSERIAL PRIMARY KEY,
+	key VARCHAR(100) NOT NULL,
+	clicked_at TIMESTAMPTZ NOT NULL,
+	referrer TEXT,
+	user_agent TEXT,
+	ip_hash VARCHAR(64)
+);
+
+CREATE INDEX IF NOT EXISTS clicks_key_clicked_at_idx ON clicks (key, clicked_at);
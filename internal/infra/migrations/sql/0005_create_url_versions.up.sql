@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS url_versions (
+	key VARCHAR(100) NOT NULL,
+	version INTEGER NOT NULL,
+	full_uri VARCHAR(1500) NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (key, version)
+);
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// The buckets of a single process
+type MemoryStore struct {
+	limit Limit
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore(limit Limit) *MemoryStore {
+	return &MemoryStore{
+		limit:   limit,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	if n > s.limit.Burst {
+		return false, 0, ErrExceedsBurst
+	}
+
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	b, found := s.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(s.limit.Burst), updated: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(s.limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*s.limit.Rate)
+	b.updated = now
+	if b.tokens < float64(n) {
+		return false, s.limit.retryAfter(b.tokens, n), nil
+	}
+	b.tokens -= float64(n)
+
+	return true, 0, nil
+}
+
+func (s *MemoryStore) Refund(ctx context.Context, key string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The forgotten bucket is full already
+	if b, found := s.buckets[key]; found {
+		b.tokens = math.Min(float64(s.limit.Burst), b.tokens+float64(n))
+	}
+
+	return nil
+}
+
+// Forget the full buckets so that the map doesn't grow with every client ever seen
+func (s *MemoryStore) sweep(now time.Time) {
+	fillTime := s.limit.fillTime()
+	if now.Sub(s.lastSweep) < fillTime {
+		return
+	}
+
+	for key, b := range s.buckets {
+		if now.Sub(b.updated) >= fillTime {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
@@ -0,0 +1,64 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MemoryStore(t *testing.T) {
+	now := time.Now()
+	store := NewMemoryStore(PerMinute(60, 3))
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	take := func(key string, n int) (bool, time.Duration) {
+		allowed, retryAfter, err := store.Take(ctx, key, n)
+		require.NoError(t, err)
+		return allowed, retryAfter
+	}
+
+	// Test case: The burst is spent
+	allowed, _ := take("a", 2)
+	assert.True(t, allowed)
+	allowed, _ = take("a", 1)
+	assert.True(t, allowed)
+	allowed, retryAfter := take("a", 2)
+	assert.False(t, allowed)
+	assert.Equal(t, 2*time.Second, retryAfter)
+
+	// Test case: Another client has its own bucket
+	allowed, _ = take("b", 3)
+	assert.True(t, allowed)
+
+	// Test case: The bucket refills with time
+	now = now.Add(time.Second)
+	allowed, retryAfter = take("a", 2)
+	assert.False(t, allowed)
+	assert.Equal(t, time.Second, retryAfter)
+	allowed, _ = take("a", 1)
+	assert.True(t, allowed)
+
+	// Test case: The refunded tokens can be taken again, the bucket holds no more than the burst
+	require.NoError(t, store.Refund(ctx, "b", 2))
+	allowed, _ = take("b", 2)
+	assert.True(t, allowed)
+	require.NoError(t, store.Refund(ctx, "b", 5))
+	allowed, _ = take("b", 3)
+	assert.True(t, allowed)
+	allowed, _ = take("b", 1)
+	assert.False(t, allowed)
+
+	// Test case: The request which never fits
+	_, _, err := store.Take(ctx, "a", 4)
+	assert.ErrorIs(t, err, ErrExceedsBurst)
+
+	// Test case: The full buckets are forgotten
+	now = now.Add(time.Minute)
+	allowed, _ = take("c", 1)
+	assert.True(t, allowed)
+	assert.Len(t, store.buckets, 1)
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// The buckets in the rate_limits table shared by all the instances. The clock of the database is used,
+// so the clocks of the instances don't have to agree
+type PostgresStore struct {
+	db    *sql.DB
+	limit Limit
+
+	// Unix nanoseconds of the last removal of the full buckets
+	lastSweep atomic.Int64
+}
+
+func NewPostgresStore(db *sql.DB, limit Limit) *PostgresStore {
+	return &PostgresStore{db: db, limit: limit}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	if n > s.limit.Burst {
+		return false, 0, ErrExceedsBurst
+	}
+
+	s.sweep(ctx)
+
+	// The row is locked by the upsert, so the concurrent requests of the same client are serialized.
+	// Nothing is returned if there are not enough tokens
+	var tokens float64
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO rate_limits AS b (key, tokens, updated_at) VALUES ($1, $2::float8 - $3::float8, now())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $4::float8) - $3::float8,
+			updated_at = now()
+		WHERE LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $4::float8) >= $3::float8
+		RETURNING tokens
+	`, key, s.limit.Burst, n, s.limit.Rate).Scan(&tokens)
+	if err == nil {
+		return true, 0, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT LEAST($2::float8, tokens + EXTRACT(EPOCH FROM now() - updated_at)::float8 * $3::float8)
+		FROM rate_limits WHERE key = $1
+	`, key, s.limit.Burst, s.limit.Rate).Scan(&tokens)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+
+	return false, s.limit.retryAfter(tokens, n), nil
+}
+
+func (s *PostgresStore) Refund(ctx context.Context, key string, n int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE rate_limits SET tokens = LEAST($2::float8, tokens + $3::float8) WHERE key = $1`, key, s.limit.Burst, n)
+	return err
+}
+
+// Remove the full buckets once in a while. Only one request of the instance does it, the failures are
+// left for the next time
+func (s *PostgresStore) sweep(ctx context.Context) {
+	now := time.Now()
+	fillTime := s.limit.fillTime()
+
+	last := s.lastSweep.Load()
+	if now.Sub(time.Unix(0, last)) < fillTime || !s.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	_, _ = s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE updated_at < now() - make_interval(secs => $1)`, fillTime.Seconds())
+}
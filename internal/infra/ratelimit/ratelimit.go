@@ -0,0 +1,47 @@
+// Package ratelimit implements the token bucket rate limiter. The buckets live either in the memory
+// of the process or in PostgreSQL, so that several instances of the shortener share the limits
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// The request costs more tokens than a full bucket holds, so it is never allowed
+var ErrExceedsBurst = errors.New("the request exceeds the burst of the rate limit")
+
+// A bucket holds up to Burst tokens and gains Rate tokens per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// The limit of the requests per minute. The burst defaults to the number of the requests per minute
+func PerMinute(requests int, burst int) Limit {
+	if burst <= 0 {
+		burst = requests
+	}
+
+	return Limit{Rate: float64(requests) / 60, Burst: burst}
+}
+
+type Store interface {
+	// Take n tokens from the bucket of the key. If there are not enough of them, nothing is taken and
+	// the time after which there will be enough is returned
+	Take(ctx context.Context, key string, n int) (allowed bool, retryAfter time.Duration, err error)
+	// Put back n tokens taken from the bucket of the key, the bucket never holds more than the burst
+	Refund(ctx context.Context, key string, n int) error
+}
+
+// The time after which the bucket will have n tokens
+func (l Limit) retryAfter(tokens float64, n int) time.Duration {
+	return time.Duration(math.Ceil((float64(n) - tokens) / l.Rate * float64(time.Second)))
+}
+
+// The time it takes an empty bucket to fill up. The buckets which have been idle for longer are full,
+// so they are the same as the missing ones
+func (l Limit) fillTime() time.Duration {
+	return l.retryAfter(0, l.Burst)
+}
@@ -0,0 +1,16 @@
+package urls
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+type Click = storage.Click
+
+// The click log is kept next to the storage file, e.g. /tmp/short-url-db.clicks.json
+func clickLogPath(storageFile string) string {
+	ext := filepath.Ext(storageFile)
+	return strings.TrimSuffix(storageFile, ext) + ".clicks" + ext
+}
@@ -0,0 +1,5 @@
+package urls
+
+import "github.com/nomardt/urlshortener-x/pkg/storage"
+
+type DeleteRequest = storage.DeleteRequest
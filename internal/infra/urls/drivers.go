@@ -0,0 +1,54 @@
+package urls
+
+import (
+	"errors"
+	"net/url"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+var ErrNoStorageFile = errors.New("please specify the path of the storage file! Example: file:///tmp/short-url-db.json")
+
+// The built-in backends are registered along with the repositories
+func init() {
+	storage.Register("memory", openMemory)
+	storage.Register("file", openFile)
+	storage.Register("postgres", openPostgres)
+	storage.Register("postgresql", openPostgres)
+}
+
+// The URLs are lost once the server is stopped
+func openMemory(_ *url.URL, config conf.Configuration) (storage.Repository, error) {
+	config.StorageFile = ""
+	return NewInMemoryRepo(config), nil
+}
+
+// The URLs are kept in memory and journaled in the file. Both file:///absolute/path and file://relative/path work
+func openFile(dsn *url.URL, config conf.Configuration) (storage.Repository, error) {
+	path := dsn.Opaque
+	if path == "" {
+		path = dsn.Host + dsn.Path
+	}
+	if path == "" {
+		return nil, ErrNoStorageFile
+	}
+
+	config.StorageFile = path
+	// The file which can't be used fails the startup, otherwise every URL would be lost on restart
+	repo, err := OpenInMemoryRepo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
+
+func openPostgres(dsn *url.URL, config conf.Configuration) (storage.Repository, error) {
+	repo, err := NewPostgresRepo(dsn.String(), config)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo, nil
+}
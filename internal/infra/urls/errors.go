@@ -1,24 +1,24 @@
 package urls
 
-import "errors"
+import "github.com/nomardt/urlshortener-x/pkg/storage"
 
+// The errors are shared with the storage API, so that the drivers of other backends can report the same failures
 var (
-	ErrNotFoundURL    = errors.New("the URL with the specified id was not found")
-	ErrCorIDNotUnique = errors.New("the specified correlation ID is not unique")
-)
+	ErrNotFoundURL     = storage.ErrNotFoundURL
+	ErrCorIDNotUnique  = storage.ErrCorIDNotUnique
+	ErrDeletedURL      = storage.ErrDeletedURL
+	ErrKeyNotUnique    = storage.ErrKeyNotUnique
+	ErrExpiredURL      = storage.ErrExpiredURL
+	ErrNotURLOwner     = storage.ErrNotURLOwner
+	ErrURLExists       = storage.ErrURLExists
+	ErrNotFoundVersion = storage.ErrNotFoundVersion
 
-type ErrURINotUnique struct {
-	ExistingKey string
-	Msg         string
-}
+	ErrStorageTimeout     = storage.ErrStorageTimeout
+	ErrStorageUnavailable = storage.ErrStorageUnavailable
+)
 
-func (e *ErrURINotUnique) Error() string {
-	return e.Msg
-}
+type ErrURINotUnique = storage.ErrURINotUnique
 
 func newErrURINotUnique(key string) error {
-	return &ErrURINotUnique{
-		ExistingKey: key,
-		Msg:         "the specified full URI is not unique",
-	}
+	return storage.NewErrURINotUnique(key)
 }
@@ -1,11 +1,14 @@
 package urls
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"sort"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -14,119 +17,1019 @@ import (
 	"github.com/nomardt/urlshortener-x/internal/infra/logger"
 )
 
+// All the lookups are done with hash indexes, so they take the same time no matter how many URLs are stored
 type InMemoryRepo struct {
-	urls []urlInFile
-	file string
-	mu   sync.Mutex
+	byKey         map[string]*urlInFile
+	byOriginalURL map[string]*urlInFile
+	byCorID       map[string]*urlInFile
+	byUser        map[string][]*urlInFile
+	clicks        map[string][]Click
+	clicksMu      sync.Mutex
+	// The versions of the edited URLs, they are guarded by mu
+	versions       map[string][]URLVersion
+	file           string
+	journal        *journal
+	clickJournal   *journal
+	versionJournal *journal
+	mu             sync.RWMutex
+	stop           chan struct{}
+	wg             sync.WaitGroup
 }
 
 type urlInFile struct {
-	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	ShortURL      string     `json:"short_url"`
+	OriginalURL   string     `json:"original_url"`
+	UserID        string     `json:"user_id,omitempty"`
+	IsDeleted     bool       `json:"is_deleted,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
 }
 
-// Create a new Repo which consists of urls map[string]string
+func newURLInFile(url *urlsDomain.URL) *urlInFile {
+	u := &urlInFile{
+		CorrelationID: url.CorrelationID(),
+		ShortURL:      url.ID(),
+		OriginalURL:   url.LongURL(),
+		UserID:        url.UserID(),
+	}
+	if expiresAt := url.ExpiresAt(); !expiresAt.IsZero() {
+		u.ExpiresAt = &expiresAt
+	}
+
+	return u
+}
+
+func newStoredURLInFile(url StoredURL) *urlInFile {
+	return &urlInFile{
+		CorrelationID: url.CorrelationID,
+		ShortURL:      url.Key,
+		OriginalURL:   url.OriginalURL,
+		UserID:        url.UserID,
+		IsDeleted:     url.IsDeleted,
+		ExpiresAt:     url.ExpiresAt,
+	}
+}
+
+func (u *urlInFile) stored() StoredURL {
+	return StoredURL{
+		Key:           u.ShortURL,
+		OriginalURL:   u.OriginalURL,
+		CorrelationID: u.CorrelationID,
+		UserID:        u.UserID,
+		IsDeleted:     u.IsDeleted,
+		ExpiresAt:     u.ExpiresAt,
+	}
+}
+
+func (u *urlInFile) isExpired(now time.Time) bool {
+	return u.ExpiresAt != nil && !now.Before(*u.ExpiresAt)
+}
+
+// Create a new Repo which keeps all the URLs in memory and journals them in the file specified in config.
+// The file is never truncated on load, the lines which can't be read are skipped. If the file can't be used,
+// the URLs are kept in memory only
 func NewInMemoryRepo(config conf.Configuration) *InMemoryRepo {
+	inMemoryRepo, err := newInMemoryRepo(config)
+	if err != nil {
+		logger.Log.Info("No file will be used to store shortened URLs, they will be kept in memory only", zap.Error(err))
+	}
+
+	return inMemoryRepo
+}
+
+// Create the Repo journaled in the storage file. Unlike NewInMemoryRepo, it fails if the file can't be used,
+// so that the URLs are never silently lost on restart
+func OpenInMemoryRepo(config conf.Configuration) (*InMemoryRepo, error) {
+	inMemoryRepo, err := newInMemoryRepo(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return inMemoryRepo, nil
+}
+
+// The Repo is returned even if the storage file can't be used, it keeps the URLs in memory then
+func newInMemoryRepo(config conf.Configuration) (*InMemoryRepo, error) {
 	inMemoryRepo := &InMemoryRepo{
-		urls: make([]urlInFile, 0),
+		byKey:         make(map[string]*urlInFile),
+		byOriginalURL: make(map[string]*urlInFile),
+		byCorID:       make(map[string]*urlInFile),
+		byUser:        make(map[string][]*urlInFile),
+		clicks:        make(map[string][]Click),
+		versions:      make(map[string][]URLVersion),
+		file:          config.StorageFile,
+		stop:          make(chan struct{}),
+	}
+	if config.StorageFile == "" {
+		return inMemoryRepo, nil
+	}
+
+	skipped, err := inMemoryRepo.loadStoredURLs()
+	if err != nil {
+		return inMemoryRepo, fmt.Errorf("couldn't recover the previously shortened URLs: %w", err)
+	}
+	if skipped > 0 {
+		logger.Log.Warn("Some lines of the storage file are corrupted and were skipped", zap.String("file", config.StorageFile), zap.Int("skipped", skipped))
+	}
+
+	inMemoryRepo.journal, err = openJournal(config.StorageFile, config.FsyncPolicy)
+	if err != nil {
+		return inMemoryRepo, fmt.Errorf("couldn't open the storage file: %w", err)
+	}
+
+	inMemoryRepo.openClickLog(config)
+	inMemoryRepo.openVersionLog(config)
+	inMemoryRepo.startBackgroundJobs(config)
+
+	return inMemoryRepo, nil
+}
+
+// Periodically flush the journal to disk and compact it into a snapshot
+func (r *InMemoryRepo) startBackgroundJobs(config conf.Configuration) {
+	syncEnabled := config.FsyncPolicy == conf.FsyncInterval && config.FsyncInterval > 0
+	compactEnabled := config.CompactInterval > 0
+	if !syncEnabled && !compactEnabled {
+		return
 	}
-	if err := inMemoryRepo.loadStoredURLs(config); err != nil {
-		logger.Log.Info("Couldn't recover any previously shortened URLs!", zap.String("error", err.Error()))
 
-		if _, err := os.Create(config.StorageFile); err != nil {
-			logger.Log.Info("No file will be created to store shortened URLs")
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		// A nil channel never delivers, so the disabled job is never run
+		var syncTick, compactTick <-chan time.Time
+		if syncEnabled {
+			syncTicker := time.NewTicker(config.FsyncInterval)
+			defer syncTicker.Stop()
+			syncTick = syncTicker.C
+		}
+		if compactEnabled {
+			compactTicker := time.NewTicker(config.CompactInterval)
+			defer compactTicker.Stop()
+			compactTick = compactTicker.C
 		}
+
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-syncTick:
+				if err := r.journal.sync(); err != nil {
+					logger.Log.Info("Couldn't flush the storage file to disk", zap.Error(err))
+				}
+				if r.clickJournal != nil {
+					if err := r.clickJournal.sync(); err != nil {
+						logger.Log.Info("Couldn't flush the click log to disk", zap.Error(err))
+					}
+				}
+				if r.versionJournal != nil {
+					if err := r.versionJournal.sync(); err != nil {
+						logger.Log.Info("Couldn't flush the version log to disk", zap.Error(err))
+					}
+				}
+			case <-compactTick:
+				if err := r.compact(); err != nil {
+					logger.Log.Info("Couldn't compact the storage file", zap.Error(err))
+				}
+			}
+		}
+	}()
+}
+
+// Stop the background jobs and flush the storage file to disk
+func (r *InMemoryRepo) Close() error {
+	select {
+	case <-r.stop:
+		return nil
+	default:
+		close(r.stop)
 	}
+	r.wg.Wait()
 
-	return inMemoryRepo
+	if r.clickJournal != nil {
+		if err := r.clickJournal.close(); err != nil {
+			logger.Log.Info("Couldn't close the click log", zap.Error(err))
+		}
+	}
+	if r.versionJournal != nil {
+		if err := r.versionJournal.close(); err != nil {
+			logger.Log.Info("Couldn't close the version log", zap.Error(err))
+		}
+	}
+	if r.journal == nil {
+		return nil
+	}
+
+	return r.journal.close()
+}
+
+// Write the journal record, the failed write is reported as the unavailable storage
+func (r *InMemoryRepo) writeJournal(records ...*urlInFile) error {
+	if r.journal == nil {
+		return nil
+	}
+
+	entries := make([]any, len(records))
+	for i, record := range records {
+		entries[i] = record
+	}
+
+	if err := r.journal.append(entries...); err != nil {
+		logger.Log.Info("Couldn't store the shortened URLs in the file", zap.Error(err))
+		return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+	}
+
+	return nil
 }
 
 // Add the specified URL to the Repo
-func (r *InMemoryRepo) SaveURL(url *urlsDomain.URL) error {
+func (r *InMemoryRepo) SaveURL(ctx context.Context, url *urlsDomain.URL) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	// Checking if the provided correlation ID is unique
-	for _, savedURL := range r.urls {
-		if savedURL.CorrelationID == url.CorrelationID() {
+	if _, found := r.byCorID[url.CorrelationID()]; found {
+		logger.Log.Info("The specified correlation ID already exists", zap.String("correlation_id", url.CorrelationID()))
+		return ErrCorIDNotUnique
+	}
+
+	// Checking if the provided full URI is unique
+	if savedURL, found := r.byOriginalURL[url.LongURL()]; found {
+		logger.Log.Info("The specified full URI already exists", zap.String("full_uri", url.LongURL()))
+		return newErrURINotUnique(savedURL.ShortURL)
+	}
+
+	// Checking if the key isn't taken by another URL
+	if _, found := r.byKey[url.ID()]; found {
+		logger.Log.Info("The specified key already exists", zap.String("key", url.ID()))
+		return ErrKeyNotUnique
+	}
+
+	// Saving the new URL on the hard drive before it becomes visible
+	newURL := newURLInFile(url)
+	if err := r.writeJournal(newURL); err != nil {
+		return err
+	}
+	r.index(newURL)
+
+	return nil
+}
+
+// Add all the specified URLs to the Repo or none of them. The returned keys are in the order of the URLs,
+// a URL whose full URI is already stored gets the existing key
+func (r *InMemoryRepo) SaveURLs(ctx context.Context, urls []*urlsDomain.URL) ([]string, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	keys := make([]string, len(urls))
+	newURLs := make([]*urlInFile, 0, len(urls))
+	batchCorIDs := make(map[string]struct{}, len(urls))
+	batchKeys := make(map[string]struct{}, len(urls))
+	batchURIs := make(map[string]string, len(urls))
+	for i, url := range urls {
+		// Checking if the provided correlation ID is unique both in the Repo and in the batch
+		_, duplicate := batchCorIDs[url.CorrelationID()]
+		if _, found := r.byCorID[url.CorrelationID()]; found || duplicate {
 			logger.Log.Info("The specified correlation ID already exists", zap.String("correlation_id", url.CorrelationID()))
-			return ErrCorIDNotUnique
+			return nil, ErrCorIDNotUnique
+		}
+		batchCorIDs[url.CorrelationID()] = struct{}{}
+
+		// The full URI which is already shortened isn't saved again
+		if savedURL, found := r.byOriginalURL[url.LongURL()]; found {
+			keys[i] = savedURL.ShortURL
+			continue
 		}
+		if key, found := batchURIs[url.LongURL()]; found {
+			keys[i] = key
+			continue
+		}
+
+		// Checking if the key isn't taken by another URL
+		_, duplicate = batchKeys[url.ID()]
+		if _, found := r.byKey[url.ID()]; found || duplicate {
+			logger.Log.Info("The specified key already exists", zap.String("key", url.ID()))
+			return nil, ErrKeyNotUnique
+		}
+		batchKeys[url.ID()] = struct{}{}
+		batchURIs[url.LongURL()] = url.ID()
+
+		keys[i] = url.ID()
+		newURLs = append(newURLs, newURLInFile(url))
 	}
 
-	// Checking if the provided full URI is unique
-	for _, savedURL := range r.urls {
-		if savedURL.OriginalURL == url.LongURL() {
-			logger.Log.Info("The specified full URI already exists", zap.String("full_uri", url.LongURL()))
-			return newErrURINotUnique(savedURL.ShortURL)
+	if len(newURLs) > 0 {
+		if err := r.writeJournal(newURLs...); err != nil {
+			return nil, err
 		}
 	}
+	for _, newURL := range newURLs {
+		r.index(newURL)
+	}
+
+	return keys, nil
+}
+
+// Check if there is a URL stored in the Repo with the specified ID
+func (r *InMemoryRepo) GetURL(ctx context.Context, id string) (string, error) {
+	if err := contextError(ctx); err != nil {
+		return "", err
+	}
 
-	r.urls = append(r.urls, urlInFile{url.CorrelationID(), url.ID(), url.LongURL()})
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	url, found := r.byKey[id]
+	if !found || url.OriginalURL == "" {
+		return "", ErrNotFoundURL
+	}
+	if url.IsDeleted {
+		return "", ErrDeletedURL
+	}
+	if url.isExpired(time.Now()) {
+		return "", ErrExpiredURL
+	}
+
+	return url.OriginalURL, nil
+}
 
-	// Saving the new URL on the hard drive
-	if file, err := os.OpenFile(r.file, os.O_WRONLY|os.O_APPEND, 0666); err == nil {
-		jsonURL := &urlInFile{
-			CorrelationID: url.CorrelationID(),
-			ShortURL:      url.ID(),
-			OriginalURL:   url.LongURL(),
+// Get all the URLs shortened by the specified user
+func (r *InMemoryRepo) GetURLsByUser(ctx context.Context, userID string) ([]*urlsDomain.URL, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	userURLs := make([]*urlsDomain.URL, 0)
+	for _, url := range r.byUser[userID] {
+		if url.OriginalURL == "" || url.IsDeleted || url.isExpired(now) {
+			continue
 		}
-		data, err := json.Marshal(jsonURL)
+
+		u, err := urlsDomain.NewURL(url.OriginalURL, url.ShortURL, url.CorrelationID, url.UserID)
 		if err != nil {
-			logger.Log.Info("Couldn't store the shortened URL in the file", zap.String("error", err.Error()))
-			return nil
+			logger.Log.Info("Skipping an invalid stored URL", zap.String("short_url", url.ShortURL), zap.Error(err))
+			continue
+		}
+		if url.ExpiresAt != nil {
+			u.SetExpiresAt(*url.ExpiresAt)
+		}
+		userURLs = append(userURLs, u)
+	}
+
+	return userURLs, nil
+}
+
+// Mark the specified URLs as deleted. Only the URLs owned by the user in the request are affected
+func (r *InMemoryRepo) DeleteURLs(ctx context.Context, requests []DeleteRequest) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tombstones := make([]*urlInFile, 0, len(requests))
+	for _, request := range requests {
+		if r.markDeleted(request.UserID, request.Key) {
+			// The tombstone line makes the deletion survive a restart
+			tombstones = append(tombstones, &urlInFile{
+				ShortURL:  request.Key,
+				UserID:    request.UserID,
+				IsDeleted: true,
+			})
+		}
+	}
+
+	if len(tombstones) == 0 {
+		return nil
+	}
+
+	return r.writeJournal(tombstones...)
+}
+
+// Call fn with the batches of the stored URLs in the order of their keys, starting after the specified key
+func (r *InMemoryRepo) ScanStoredURLs(ctx context.Context, after string, batchSize int, fn func([]StoredURL) error) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	// The URLs are copied at once, so fn is free to write to the Repo
+	r.mu.RLock()
+	urls := make([]StoredURL, 0, len(r.byKey))
+	for key, url := range r.byKey {
+		if key > after && url.OriginalURL != "" {
+			urls = append(urls, url.stored())
+		}
+	}
+	r.mu.RUnlock()
+
+	return scanBatches(ctx, urls, batchSize, fn)
+}
+
+// The same as ScanStoredURLs but only for the URLs owned by the user
+func (r *InMemoryRepo) ScanUserURLs(ctx context.Context, userID string, after string, batchSize int, fn func([]StoredURL) error) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	urls := make([]StoredURL, 0, len(r.byUser[userID]))
+	for _, url := range r.byUser[userID] {
+		if url.ShortURL > after && url.OriginalURL != "" {
+			urls = append(urls, url.stored())
 		}
-		data = append(data, '\n')
+	}
+	r.mu.RUnlock()
+
+	return scanBatches(ctx, urls, batchSize, fn)
+}
 
-		_, _ = file.Write(data)
-		file.Close()
+// Sort the URLs by their keys and pass them to fn in batches
+func scanBatches(ctx context.Context, urls []StoredURL, batchSize int, fn func([]StoredURL) error) error {
+	if batchSize <= 0 {
+		batchSize = defaultScanBatchSize
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].Key < urls[j].Key
+	})
+
+	for start := 0; start < len(urls); start += batchSize {
+		if err := contextError(ctx); err != nil {
+			return err
+		}
+		if err := fn(urls[start:min(start+batchSize, len(urls))]); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-// Check if there is a URL stored in the Repo with the specified ID
-func (r *InMemoryRepo) GetURL(id *string) (string, error) {
+// Save the URLs exactly as they are. The results are in the order of the URLs: nil if the URL is saved,
+// ErrURLExists if the identical URL is already stored or the error of the conflict
+func (r *InMemoryRepo) SaveStoredURLs(ctx context.Context, urls []StoredURL) ([]error, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	for _, url := range r.urls {
-		if url.ShortURL == *id && url.OriginalURL != "" {
-			return url.OriginalURL, nil
+	index := newStoredIndex()
+	for _, url := range urls {
+		for _, existing := range []*urlInFile{r.byKey[url.Key], r.byCorID[url.CorrelationID], r.byOriginalURL[url.OriginalURL]} {
+			if existing != nil && existing.OriginalURL != "" {
+				index.add(existing.stored())
+			}
 		}
 	}
+	batch := index.checkBatch(urls)
+
+	records := make([]*urlInFile, 0, len(batch.newURLs)+len(batch.deletedKeys))
+	for _, url := range batch.newURLs {
+		records = append(records, newStoredURLInFile(url))
+	}
+	for _, key := range batch.deletedKeys {
+		records = append(records, &urlInFile{ShortURL: key, UserID: r.byKey[key].UserID, IsDeleted: true})
+	}
+	if len(records) == 0 {
+		return batch.results, nil
+	}
+
+	// Saving the URLs on the hard drive before they become visible
+	if err := r.writeJournal(records...); err != nil {
+		return nil, err
+	}
+	for _, record := range records[:len(batch.newURLs)] {
+		r.index(record)
+	}
+	for _, key := range batch.deletedKeys {
+		r.byKey[key].IsDeleted = true
+	}
 
-	return "", ErrNotFoundURL
+	return batch.results, nil
 }
 
-func (r *InMemoryRepo) Ping(_ context.Context) error {
-	if _, err := os.Stat(r.file); err != nil {
-		return err
-	} else {
+// Load the previously recorded clicks and open the click log for appending.
+// The clicks are kept in memory only if the log can't be opened
+func (r *InMemoryRepo) openClickLog(config conf.Configuration) {
+	path := clickLogPath(config.StorageFile)
+
+	skipped, err := replayJournal(path, func(line []byte) error {
+		var click Click
+		if err := json.Unmarshal(line, &click); err != nil {
+			return err
+		}
+		if click.Key == "" {
+			return errors.New("the key is missing")
+		}
+
+		r.clicks[click.Key] = append(r.clicks[click.Key], click)
+		return nil
+	})
+	if err != nil {
+		logger.Log.Info("Couldn't recover the previously recorded clicks", zap.Error(err))
+		return
+	}
+	if skipped > 0 {
+		logger.Log.Warn("Some lines of the click log are corrupted and were skipped", zap.String("file", path), zap.Int("skipped", skipped))
+	}
+
+	r.clickJournal, err = openJournal(path, config.FsyncPolicy)
+	if err != nil {
+		logger.Log.Info("No file will be used to store clicks", zap.Error(err))
+	}
+}
+
+// Load the versions of the edited URLs and open the version log for appending.
+// The versions are kept in memory only if the log can't be opened
+func (r *InMemoryRepo) openVersionLog(config conf.Configuration) {
+	path := versionLogPath(config.StorageFile)
+
+	skipped, err := replayJournal(path, func(line []byte) error {
+		var version URLVersion
+		if err := json.Unmarshal(line, &version); err != nil {
+			return err
+		}
+		if version.Key == "" {
+			return errors.New("the key is missing")
+		}
+		// The versions of the URLs removed before the version log was rewritten are skipped
+		if _, found := r.byKey[version.Key]; !found {
+			return nil
+		}
+
+		r.versions[version.Key] = append(r.versions[version.Key], version)
 		return nil
+	})
+	if err != nil {
+		logger.Log.Info("Couldn't recover the versions of the edited URLs", zap.Error(err))
+		return
+	}
+	if skipped > 0 {
+		logger.Log.Warn("Some lines of the version log are corrupted and were skipped", zap.String("file", path), zap.Int("skipped", skipped))
+	}
+
+	r.versionJournal, err = openJournal(path, config.FsyncPolicy)
+	if err != nil {
+		logger.Log.Info("No file will be used to store the versions of the URLs", zap.Error(err))
 	}
 }
 
-// Load previously shortened URLs from the file specified in config
-func (r *InMemoryRepo) loadStoredURLs(config conf.Configuration) error {
-	r.file = config.StorageFile
+// Point the URL owned by the user at the new destination. The change is recorded as a new version
+func (r *InMemoryRepo) UpdateURL(ctx context.Context, key string, userID string, originalURL string) (*URLVersion, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	file, err := os.Open(config.StorageFile)
+	url, err := r.editableURL(key, userID)
 	if err != nil {
+		return nil, err
+	}
+
+	return r.changeURL(url, originalURL)
+}
+
+// Point the URL owned by the user back at the destination of the version. The rollback is recorded
+// as a new version, the history itself is never rewritten
+func (r *InMemoryRepo) RollbackURL(ctx context.Context, key string, userID string, version int) (*URLVersion, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	url, err := r.editableURL(key, userID)
+	if err != nil {
+		return nil, err
+	}
+	history := r.history(url)
+	if version < 1 || version > len(history) {
+		return nil, ErrNotFoundVersion
+	}
+
+	return r.changeURL(url, history[version-1].OriginalURL)
+}
+
+// Get every version of the URL owned by the user, the oldest one goes first
+func (r *InMemoryRepo) GetURLVersions(ctx context.Context, key string, userID string) ([]URLVersion, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	url, found := r.byKey[key]
+	if !found || url.OriginalURL == "" {
+		return nil, ErrNotFoundURL
+	}
+	if url.UserID != userID {
+		return nil, ErrNotURLOwner
+	}
+
+	return append([]URLVersion(nil), r.history(url)...), nil
+}
+
+// Only the owner can edit the URL which still works. The caller must hold the lock
+func (r *InMemoryRepo) editableURL(key string, userID string) (*urlInFile, error) {
+	url, found := r.byKey[key]
+	switch {
+	case !found || url.OriginalURL == "":
+		return nil, ErrNotFoundURL
+	case url.UserID != userID:
+		return nil, ErrNotURLOwner
+	case url.IsDeleted:
+		return nil, ErrDeletedURL
+	case url.isExpired(time.Now()):
+		return nil, ErrExpiredURL
+	}
+
+	return url, nil
+}
+
+// The caller must hold the lock
+func (r *InMemoryRepo) history(url *urlInFile) []URLVersion {
+	if versions := r.versions[url.ShortURL]; len(versions) > 0 {
+		return versions
+	}
+	// The time the URL was created at isn't stored
+	return []URLVersion{initialVersion(url.ShortURL, url.OriginalURL, time.Time{})}
+}
+
+// Change the destination of the URL and record the new version. Nothing is recorded if the destination
+// is the same. The caller must hold the write lock
+func (r *InMemoryRepo) changeURL(url *urlInFile, originalURL string) (*URLVersion, error) {
+	history := r.history(url)
+	if url.OriginalURL == originalURL {
+		latest := history[len(history)-1]
+		return &latest, nil
+	}
+	if existing, found := r.byOriginalURL[originalURL]; found {
+		logger.Log.Info("The specified full URI already exists", zap.String("full_uri", originalURL))
+		return nil, newErrURINotUnique(existing.ShortURL)
+	}
+
+	var newVersions []URLVersion
+	if len(r.versions[url.ShortURL]) == 0 {
+		newVersions = append(newVersions, history[0])
+	}
+	version := URLVersion{Key: url.ShortURL, Version: len(history) + 1, OriginalURL: originalURL, CreatedAt: time.Now().UTC()}
+	newVersions = append(newVersions, version)
+
+	// The version is recorded first, so that no change of the URL is missing from the history
+	if err := r.writeVersions(newVersions...); err != nil {
+		return nil, err
+	}
+	updated := *url
+	updated.OriginalURL = originalURL
+	if err := r.writeJournal(&updated); err != nil {
+		// The URL isn't changed, so its history is rewritten without the version which was just recorded
+		if err := r.writeVersionSnapshot(); err != nil {
+			logger.Log.Info("Couldn't remove the version of the unchanged URL from the file", zap.String("key", url.ShortURL), zap.Error(err))
+		}
+		return nil, err
+	}
+
+	r.versions[url.ShortURL] = append(r.versions[url.ShortURL], newVersions...)
+	delete(r.byOriginalURL, url.OriginalURL)
+	url.OriginalURL = originalURL
+	r.byOriginalURL[originalURL] = url
+
+	return &version, nil
+}
+
+// Append the versions to the version log, the failed write is reported as the unavailable storage
+func (r *InMemoryRepo) writeVersions(versions ...URLVersion) error {
+	if r.versionJournal == nil {
+		return nil
+	}
+
+	entries := make([]any, len(versions))
+	for i, version := range versions {
+		entries[i] = version
+	}
+
+	if err := r.versionJournal.append(entries...); err != nil {
+		logger.Log.Info("Couldn't store the versions of the URL in the file", zap.Error(err))
+		return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+	}
+
+	return nil
+}
+
+// Record the clicks in the Repo and append them to the click log
+func (r *InMemoryRepo) SaveClicks(ctx context.Context, clicks []Click) error {
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+
+	// The clicks have their own lock so that recording them doesn't hold up the redirects
+	r.clicksMu.Lock()
+	defer r.clicksMu.Unlock()
+
+	if r.clickJournal != nil {
+		entries := make([]any, len(clicks))
+		for i, click := range clicks {
+			entries[i] = click
+		}
+
+		if err := r.clickJournal.append(entries...); err != nil {
+			return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+		}
+	}
+
+	for _, click := range clicks {
+		r.clicks[click.Key] = append(r.clicks[click.Key], click)
+	}
+
+	return nil
+}
+
+// Get the click statistics of the URL owned by the user
+func (r *InMemoryRepo) GetURLStats(ctx context.Context, key string, userID string) (*URLStats, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	// The URL can be edited concurrently, so its fields are only read under the lock
+	r.mu.RLock()
+	var originalURL, owner string
+	url, found := r.byKey[key]
+	if found {
+		originalURL, owner = url.OriginalURL, url.UserID
+	}
+	r.mu.RUnlock()
+	if !found || originalURL == "" {
+		return nil, ErrNotFoundURL
+	}
+	if owner != userID {
+		return nil, ErrNotURLOwner
+	}
+
+	r.clicksMu.Lock()
+	defer r.clicksMu.Unlock()
+
+	return newURLStats(key, r.clicks[key]), nil
+}
+
+// Count the links which work and the users who own them
+func (r *InMemoryRepo) GetServiceStats(ctx context.Context) (*ServiceStats, error) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	stats := &ServiceStats{}
+	users := make(map[string]struct{})
+	for _, url := range r.byKey {
+		if url.OriginalURL == "" || url.IsDeleted || url.isExpired(now) {
+			continue
+		}
+
+		stats.URLs++
+		if url.UserID != "" {
+			users[url.UserID] = struct{}{}
+		}
+	}
+	stats.Users = len(users)
+
+	return stats, nil
+}
+
+// Count every stored key, the deleted and the expired URLs keep theirs until they are swept
+func (r *InMemoryRepo) CountKeys(ctx context.Context) (int, error) {
+	if err := contextError(ctx); err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.byKey), nil
+}
+
+// Remove the expired URLs from the Repo and compact the file so that it doesn't keep them either
+func (r *InMemoryRepo) DeleteExpiredURLs(ctx context.Context) (int, error) {
+	if err := contextError(ctx); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var removedKeys []string
+	purgedVersions := false
+	for _, url := range r.byKey {
+		if url.isExpired(now) {
+			r.unindex(url)
+			if _, found := r.versions[url.ShortURL]; found {
+				delete(r.versions, url.ShortURL)
+				purgedVersions = true
+			}
+			removedKeys = append(removedKeys, url.ShortURL)
+		}
+	}
+
+	removed := len(removedKeys)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	// The history and the clicks are purged first, otherwise the key could be taken again and inherit them
+	if purgedVersions {
+		if err := r.writeVersionSnapshot(); err != nil {
+			return removed, err
+		}
+	}
+	if err := r.purgeClicks(removedKeys); err != nil {
+		return removed, err
+	}
+
+	return removed, r.writeSnapshot()
+}
+
+// Compact the journal into a snapshot if anything was appended to it since the last compaction
+func (r *InMemoryRepo) compact() error {
+	if !r.journal.needsCompaction() {
+		return nil
+	}
+
+	// The read lock keeps the writers from appending to the journal which is being replaced
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.writeSnapshot()
+}
+
+// Rewrite the journal with the URLs currently in the Repo. The caller must hold the lock
+func (r *InMemoryRepo) writeSnapshot() error {
+	if r.journal == nil {
+		return nil
+	}
+
+	return r.journal.rewrite(func(encoder *json.Encoder) error {
+		for _, url := range r.byKey {
+			if err := encoder.Encode(url); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Rewrite the version log with the versions of the URLs currently in the Repo. The caller must hold the lock
+func (r *InMemoryRepo) writeVersionSnapshot() error {
+	if r.versionJournal == nil {
+		return nil
+	}
+
+	return r.versionJournal.rewrite(func(encoder *json.Encoder) error {
+		for _, versions := range r.versions {
+			for _, version := range versions {
+				if err := encoder.Encode(version); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Forget the clicks of the removed keys and rewrite the click log if any of them had clicks
+func (r *InMemoryRepo) purgeClicks(keys []string) error {
+	r.clicksMu.Lock()
+	defer r.clicksMu.Unlock()
+
+	purged := false
+	for _, key := range keys {
+		if _, found := r.clicks[key]; found {
+			delete(r.clicks, key)
+			purged = true
+		}
+	}
+	if !purged || r.clickJournal == nil {
+		return nil
+	}
+
+	return r.clickJournal.rewrite(func(encoder *json.Encoder) error {
+		for _, clicks := range r.clicks {
+			for _, click := range clicks {
+				if err := encoder.Encode(click); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// Add the URL to every index. The caller must hold the write lock
+func (r *InMemoryRepo) index(url *urlInFile) {
+	r.byKey[url.ShortURL] = url
+	r.byOriginalURL[url.OriginalURL] = url
+	r.byCorID[url.CorrelationID] = url
+	r.byUser[url.UserID] = append(r.byUser[url.UserID], url)
+}
+
+// Remove the URL from every index. The caller must hold the write lock
+func (r *InMemoryRepo) unindex(url *urlInFile) {
+	delete(r.byKey, url.ShortURL)
+	delete(r.byOriginalURL, url.OriginalURL)
+	delete(r.byCorID, url.CorrelationID)
+
+	userURLs := r.byUser[url.UserID]
+	for i, userURL := range userURLs {
+		if userURL == url {
+			r.byUser[url.UserID] = append(userURLs[:i], userURLs[i+1:]...)
+			break
+		}
+	}
+	if len(r.byUser[url.UserID]) == 0 {
+		delete(r.byUser, url.UserID)
+	}
+}
+
+func (r *InMemoryRepo) markDeleted(userID string, key string) bool {
+	url, found := r.byKey[key]
+	if !found || url.UserID != userID || url.IsDeleted {
+		return false
+	}
+
+	url.IsDeleted = true
+	return true
+}
+
+func (r *InMemoryRepo) Ping(ctx context.Context) error {
+	if err := contextError(ctx); err != nil {
 		return err
 	}
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := scanner.Bytes()
+	// The URLs kept in memory only have no file to check
+	if r.file == "" {
+		return nil
+	}
+
+	if _, err := os.Stat(r.file); err != nil {
+		return err
+	} else {
+		return nil
+	}
+}
+
+// Load previously shortened URLs from the storage file. The number of skipped corrupted lines is returned
+func (r *InMemoryRepo) loadStoredURLs() (int, error) {
+	return replayJournal(r.file, func(line []byte) error {
 		url := &urlInFile{}
 		if err := json.Unmarshal(line, url); err != nil {
 			return err
 		}
+		if url.ShortURL == "" {
+			return errors.New("the short URL is missing")
+		}
 
-		r.urls = append(r.urls, *url)
-	}
+		// Tombstones only carry the key and the owner of a deleted URL
+		if url.IsDeleted && url.OriginalURL == "" {
+			r.markDeleted(url.UserID, url.ShortURL)
+			return nil
+		}
 
+		// The edited URL is journaled again with its new destination
+		if existing, found := r.byKey[url.ShortURL]; found {
+			r.unindex(existing)
+		}
+		r.index(url)
+		return nil
+	})
+}
+
+// The in-memory operations are fast, so it is enough to check the context before starting one
+func contextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %w", ErrStorageTimeout, err)
+		}
+		return err
+	}
 	return nil
 }
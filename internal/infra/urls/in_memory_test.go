@@ -1,16 +1,25 @@
 package urls
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	conf "github.com/nomardt/urlshortener-x/cmd/config"
 	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
 )
 
-func newMockConfig(listenAddress string, path string) conf.Configuration {
+func newMockConfig(listenAddress string, baseURL string) conf.Configuration {
 	return conf.Configuration{
 		ListenAddress: listenAddress,
-		Path:          path,
+		BaseURL:       baseURL,
 	}
 }
 
@@ -18,24 +27,31 @@ func Test_SaveURL(t *testing.T) {
 	repo := NewInMemoryRepo(newMockConfig("127.0.0.1:8080", ""))
 
 	// Test case: Save a URL
-	testURL, _ := urlsDomain.NewURL("https://example.com", "123", "anything")
-	err := repo.SaveURL(testURL)
+	testURL, _ := urlsDomain.NewURL("https://example.com", "123", "anything", "user")
+	err := repo.SaveURL(context.Background(), testURL)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
+
+	// Test case: Save another URL with the same key
+	testURL, _ = urlsDomain.NewURL("https://example.org", "123", "another", "user")
+	err = repo.SaveURL(context.Background(), testURL)
+	if !errors.Is(err, ErrKeyNotUnique) {
+		t.Errorf("Expected ErrKeyNotUnique, got: %v", err)
+	}
 }
 
 func Test_GetURL(t *testing.T) {
 	repo := NewInMemoryRepo(newMockConfig("127.0.0.1:8080", ""))
 
 	// Test case: Get existing URL
-	testURL, _ := urlsDomain.NewURL("https://example.com", "123", "anything")
-	err := repo.SaveURL(testURL)
+	testURL, _ := urlsDomain.NewURL("https://example.com", "123", "anything", "user")
+	err := repo.SaveURL(context.Background(), testURL)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
 	tc := "123"
-	foundURL, err := repo.GetURL(&tc)
+	foundURL, err := repo.GetURL(context.Background(), tc)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
@@ -45,7 +61,7 @@ func Test_GetURL(t *testing.T) {
 
 	// Test case: Get non-existing URL
 	tc = "456"
-	foundURL, err = repo.GetURL(&tc)
+	foundURL, err = repo.GetURL(context.Background(), tc)
 	if err == nil {
 		t.Errorf("Expected an error, got nil")
 	}
@@ -53,3 +69,586 @@ func Test_GetURL(t *testing.T) {
 		t.Errorf("Expected URL to be empty, got '%s'", foundURL)
 	}
 }
+
+func Test_GetURLsByUser(t *testing.T) {
+	repo := NewInMemoryRepo(newMockConfig("127.0.0.1:8080", ""))
+
+	testURL, _ := urlsDomain.NewURL("https://example.com", "123", "first", "user")
+	if err := repo.SaveURL(context.Background(), testURL); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	testURL, _ = urlsDomain.NewURL("https://example.org", "456", "second", "another user")
+	if err := repo.SaveURL(context.Background(), testURL); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Test case: Only the URLs of the specified user are returned
+	userURLs, err := repo.GetURLsByUser(context.Background(), "user")
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if len(userURLs) != 1 || userURLs[0].ID() != "123" {
+		t.Errorf("Expected only the URL with key '123', got %d URLs", len(userURLs))
+	}
+
+	// Test case: A user without any URLs
+	userURLs, err = repo.GetURLsByUser(context.Background(), "nobody")
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if len(userURLs) != 0 {
+		t.Errorf("Expected no URLs, got %d", len(userURLs))
+	}
+}
+
+func Test_DeleteURLs(t *testing.T) {
+	repo := NewInMemoryRepo(newMockConfig("127.0.0.1:8080", ""))
+
+	testURL, _ := urlsDomain.NewURL("https://example.com", "123", "anything", "user")
+	if err := repo.SaveURL(context.Background(), testURL); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Test case: Another user can't delete the URL
+	if err := repo.DeleteURLs(context.Background(), []DeleteRequest{{UserID: "another user", Key: "123"}}); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	tc := "123"
+	if _, err := repo.GetURL(context.Background(), tc); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Test case: The owner deletes the URL
+	if err := repo.DeleteURLs(context.Background(), []DeleteRequest{{UserID: "user", Key: "123"}}); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if _, err := repo.GetURL(context.Background(), tc); !errors.Is(err, ErrDeletedURL) {
+		t.Errorf("Expected ErrDeletedURL, got: %v", err)
+	}
+}
+
+func Test_CountKeys(t *testing.T) {
+	repo := NewInMemoryRepo(newMockConfig("127.0.0.1:8080", ""))
+
+	aliveURL, _ := urlsDomain.NewURL("https://example.com/alive", "alive", "alive", "user")
+	deletedURL, _ := urlsDomain.NewURL("https://example.com/deleted", "deleted", "deleted", "user")
+	expiredURL, _ := urlsDomain.NewURL("https://example.com/expired", "expired", "expired", "user")
+	expiredURL.SetExpiresAt(time.Now().Add(-time.Minute))
+	for _, url := range []*urlsDomain.URL{aliveURL, deletedURL, expiredURL} {
+		if err := repo.SaveURL(context.Background(), url); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.DeleteURLs(context.Background(), []DeleteRequest{{UserID: "user", Key: "deleted"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case: The deleted and the expired URLs still take their keys
+	if count, err := repo.CountKeys(context.Background()); err != nil || count != 3 {
+		t.Errorf("Expected 3 keys, got %d and %v", count, err)
+	}
+	if stats, err := repo.GetServiceStats(context.Background()); err != nil || stats.URLs != 1 {
+		t.Errorf("Expected 1 working URL, got %v and %v", stats, err)
+	}
+
+	// Test case: The swept keys are free
+	if _, err := repo.DeleteExpiredURLs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := repo.CountKeys(context.Background()); err != nil || count != 2 {
+		t.Errorf("Expected 2 keys, got %d and %v", count, err)
+	}
+}
+
+func Test_DeleteExpiredURLs(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.StorageFile = filepath.Join(t.TempDir(), "short-url-db.json")
+	repo := NewInMemoryRepo(config)
+
+	expiredURL, _ := urlsDomain.NewURL("https://example.com", "123", "expired", "user")
+	expiredURL.SetExpiresAt(time.Now().Add(-time.Minute))
+	if err := repo.SaveURL(context.Background(), expiredURL); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	aliveURL, _ := urlsDomain.NewURL("https://example.org", "456", "alive", "user")
+	aliveURL.SetExpiresAt(time.Now().Add(time.Hour))
+	if err := repo.SaveURL(context.Background(), aliveURL); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Test case: The expired URL doesn't redirect anymore
+	tc := "123"
+	if _, err := repo.GetURL(context.Background(), tc); !errors.Is(err, ErrExpiredURL) {
+		t.Errorf("Expected ErrExpiredURL, got: %v", err)
+	}
+
+	// Test case: The sweeper removes only the expired URL
+	removed, err := repo.DeleteExpiredURLs(context.Background())
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 URL to be removed, got %d", removed)
+	}
+
+	// Test case: The compacted file doesn't contain the expired URL
+	reloaded := NewInMemoryRepo(config)
+	if _, err := reloaded.GetURL(context.Background(), tc); !errors.Is(err, ErrNotFoundURL) {
+		t.Errorf("Expected ErrNotFoundURL, got: %v", err)
+	}
+	tc = "456"
+	if _, err := reloaded.GetURL(context.Background(), tc); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+}
+
+func Test_DeleteExpiredURLsPurgesVersions(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.StorageFile = filepath.Join(t.TempDir(), "short-url-db.json")
+	repo := NewInMemoryRepo(config)
+
+	expiredURL, _ := urlsDomain.NewURL("https://example.com/v1", "key", "expired", "user")
+	expiredURL.SetExpiresAt(time.Now().Add(time.Hour))
+	if err := repo.SaveURL(context.Background(), expiredURL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.UpdateURL(context.Background(), "key", "user", "https://example.com/v2"); err != nil {
+		t.Fatal(err)
+	}
+	// The edited URL expires, it can't be edited once it has expired
+	expiredAt := time.Now().Add(-time.Minute)
+	repo.byKey["key"].ExpiresAt = &expiredAt
+	if _, err := repo.DeleteExpiredURLs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case: The reused key starts a new history, also after a restart
+	reusedURL, _ := urlsDomain.NewURL("https://example.org", "key", "reused", "other")
+	if err := repo.SaveURL(context.Background(), reusedURL); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewInMemoryRepo(config)
+	defer reloaded.Close()
+	versions, err := reloaded.GetURLVersions(context.Background(), "key", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0].OriginalURL != "https://example.org" {
+		t.Errorf("Expected the single version of the reused key, got %v", versions)
+	}
+}
+
+func Test_DeleteExpiredURLsPurgesClicks(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.StorageFile = filepath.Join(t.TempDir(), "short-url-db.json")
+	repo := NewInMemoryRepo(config)
+
+	expiredURL, _ := urlsDomain.NewURL("https://example.com", "key", "expired", "user")
+	expiredURL.SetExpiresAt(time.Now().Add(-time.Minute))
+	if err := repo.SaveURL(context.Background(), expiredURL); err != nil {
+		t.Fatal(err)
+	}
+	clicks := []Click{
+		{Key: "key", Time: time.Now().UTC(), IPHash: "hash"},
+		{Key: "other", Time: time.Now().UTC()},
+	}
+	if err := repo.SaveClicks(context.Background(), clicks); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.DeleteExpiredURLs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case: The reused key has no clicks, also after a restart
+	reusedURL, _ := urlsDomain.NewURL("https://example.org", "key", "reused", "other")
+	if err := repo.SaveURL(context.Background(), reusedURL); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := repo.GetURLStats(context.Background(), "key", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalClicks != 0 || stats.UniqueVisitors != 0 {
+		t.Errorf("Expected no clicks, got %d clicks and %d visitors", stats.TotalClicks, stats.UniqueVisitors)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded := NewInMemoryRepo(config)
+	defer reloaded.Close()
+	stats, err = reloaded.GetURLStats(context.Background(), "key", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalClicks != 0 {
+		t.Errorf("Expected no clicks, got %d", stats.TotalClicks)
+	}
+
+	// Test case: The clicks of the other keys are kept
+	if len(reloaded.clicks["other"]) != 1 {
+		t.Errorf("Expected 1 click of the other key, got %d", len(reloaded.clicks["other"]))
+	}
+}
+
+func Test_JournalPartialWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.json")
+	j, err := openJournal(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.close()
+
+	if err := j.append(map[string]string{"key": "first"}); err != nil {
+		t.Fatal(err)
+	}
+	// The write which failed halfway through
+	partial := []byte(`{"key":"par`)
+	if _, err := j.file.Write(partial); err != nil {
+		t.Fatal(err)
+	}
+	j.discardPartialWrite(len(partial))
+	if err := j.append(map[string]string{"key": "second"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case: The record after the failed write is intact
+	var keys []string
+	skipped, err := replayJournal(path, func(line []byte) error {
+		var record map[string]string
+		if err := json.Unmarshal(line, &record); err != nil {
+			return err
+		}
+		keys = append(keys, record["key"])
+		return nil
+	})
+	if err != nil || skipped != 0 {
+		t.Errorf("Expected no skipped lines, got %d and %v", skipped, err)
+	}
+	if strings.Join(keys, ",") != "first,second" {
+		t.Errorf("Expected first,second, got %s", strings.Join(keys, ","))
+	}
+}
+
+func Test_LoadCorruptedFile(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.StorageFile = filepath.Join(t.TempDir(), "short-url-db.json")
+	content := `{"correlation_id":"first","short_url":"123","original_url":"https://example.com"}
+{"correlation_id":"broken","short_url":"45
+{"correlation_id":"second","short_url":"789","original_url":"https://example.org"}
+{"correlation_id":"truncated","short_url":"0ab","orig`
+	if err := os.WriteFile(config.StorageFile, []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case: The corrupted lines are skipped, the rest of the URLs are loaded
+	repo := NewInMemoryRepo(config)
+	for _, tc := range []string{"123", "789"} {
+		if _, err := repo.GetURL(context.Background(), tc); err != nil {
+			t.Errorf("Expected no error for %s, got: %v", tc, err)
+		}
+	}
+
+	// Test case: The new URL isn't glued to the truncated line
+	testURL, _ := urlsDomain.NewURL("https://example.net", "cde", "third", "user")
+	if err := repo.SaveURL(context.Background(), testURL); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Test case: The file isn't wiped
+	data, err := os.ReadFile(config.StorageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), content) {
+		t.Errorf("Expected the file to keep its content, got: %s", data)
+	}
+
+	reloaded := NewInMemoryRepo(config)
+	defer reloaded.Close()
+	for _, tc := range []string{"123", "789", "cde"} {
+		if _, err := reloaded.GetURL(context.Background(), tc); err != nil {
+			t.Errorf("Expected no error for %s, got: %v", tc, err)
+		}
+	}
+}
+
+func Test_CompactJournal(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.StorageFile = filepath.Join(t.TempDir(), "short-url-db.json")
+	config.FsyncPolicy = conf.FsyncAlways
+	repo := NewInMemoryRepo(config)
+
+	for i := 0; i < 3; i++ {
+		testURL, _ := urlsDomain.NewURL("https://example.com/"+strconv.Itoa(i), strconv.Itoa(i), strconv.Itoa(i), "user")
+		if err := repo.SaveURL(context.Background(), testURL); err != nil {
+			t.Errorf("Expected no error, got: %v", err)
+		}
+	}
+	if err := repo.DeleteURLs(context.Background(), []DeleteRequest{{UserID: "user", Key: "0"}}); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Test case: The tombstone is merged into the snapshot
+	if err := repo.compact(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	data, err := os.ReadFile(config.StorageFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("Expected 3 lines in the snapshot, got %d", lines)
+	}
+
+	// Test case: The URLs saved after the compaction are appended to the snapshot
+	testURL, _ := urlsDomain.NewURL("https://example.org", "3", "3", "user")
+	if err := repo.SaveURL(context.Background(), testURL); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	reloaded := NewInMemoryRepo(config)
+	defer reloaded.Close()
+	if _, err := reloaded.GetURL(context.Background(), "0"); !errors.Is(err, ErrDeletedURL) {
+		t.Errorf("Expected ErrDeletedURL, got: %v", err)
+	}
+	for _, tc := range []string{"1", "2", "3"} {
+		if _, err := reloaded.GetURL(context.Background(), tc); err != nil {
+			t.Errorf("Expected no error for %s, got: %v", tc, err)
+		}
+	}
+}
+
+func Test_SaveClicks(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.StorageFile = filepath.Join(t.TempDir(), "short-url-db.json")
+	repo := NewInMemoryRepo(config)
+
+	clicks := []Click{
+		{Key: "123", Time: time.Now().UTC(), Referrer: "https://example.org", IPHash: "hash"},
+		{Key: "123", Time: time.Now().UTC(), UserAgent: "curl/8.0"},
+		{Key: "456", Time: time.Now().UTC()},
+	}
+	if err := repo.SaveClicks(context.Background(), clicks); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Test case: The clicks are written to the click log next to the storage file
+	if _, err := os.Stat(filepath.Join(filepath.Dir(config.StorageFile), "short-url-db.clicks.json")); err != nil {
+		t.Errorf("Expected the click log to exist, got: %v", err)
+	}
+
+	// Test case: The clicks survive a restart
+	reloaded := NewInMemoryRepo(config)
+	defer reloaded.Close()
+	if len(reloaded.clicks["123"]) != 2 || len(reloaded.clicks["456"]) != 1 {
+		t.Errorf("Expected 2 and 1 clicks, got %d and %d", len(reloaded.clicks["123"]), len(reloaded.clicks["456"]))
+	}
+	if reloaded.clicks["123"][0].Referrer != "https://example.org" {
+		t.Errorf("Expected the referrer to be stored, got %q", reloaded.clicks["123"][0].Referrer)
+	}
+}
+
+func Test_UpdateURL(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.StorageFile = filepath.Join(t.TempDir(), "short-url-db.json")
+	repo := NewInMemoryRepo(config)
+
+	testURL, _ := urlsDomain.NewURL("https://example.com/v1", "key", "key", "user")
+	if err := repo.SaveURL(context.Background(), testURL); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if _, err := repo.UpdateURL(context.Background(), "key", "user", "https://example.com/v2"); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if _, err := repo.RollbackURL(context.Background(), "key", "user", 1); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if _, err := repo.UpdateURL(context.Background(), "key", "other", "https://example.com/other"); !errors.Is(err, ErrNotURLOwner) {
+		t.Errorf("Expected ErrNotURLOwner, got: %v", err)
+	}
+	if _, err := repo.RollbackURL(context.Background(), "key", "user", 4); !errors.Is(err, ErrNotFoundVersion) {
+		t.Errorf("Expected ErrNotFoundVersion, got: %v", err)
+	}
+	if _, err := repo.UpdateURL(context.Background(), "key", "user", "https://example.com/v4"); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Test case: The edits and the history survive a restart
+	reloaded := NewInMemoryRepo(config)
+	defer reloaded.Close()
+	if originalURL, err := reloaded.GetURL(context.Background(), "key"); err != nil || originalURL != "https://example.com/v4" {
+		t.Errorf("Expected the edited URL, got %q and %v", originalURL, err)
+	}
+	if _, found := reloaded.byOriginalURL["https://example.com/v1"]; found {
+		t.Errorf("Expected the old destination to be free")
+	}
+	versions, err := reloaded.GetURLVersions(context.Background(), "key", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, version := range versions {
+		got = append(got, fmt.Sprintf("%d %s", version.Version, version.OriginalURL))
+	}
+	want := "1 https://example.com/v1, 2 https://example.com/v2, 3 https://example.com/v1, 4 https://example.com/v4"
+	if strings.Join(got, ", ") != want {
+		t.Errorf("Expected %s, got %s", want, strings.Join(got, ", "))
+	}
+}
+
+func Test_UpdateURLJournalFailure(t *testing.T) {
+	config := newMockConfig("127.0.0.1:8080", "")
+	config.StorageFile = filepath.Join(t.TempDir(), "short-url-db.json")
+	repo := NewInMemoryRepo(config)
+
+	testURL, _ := urlsDomain.NewURL("https://example.com/v1", "key", "key", "user")
+	if err := repo.SaveURL(context.Background(), testURL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.UpdateURL(context.Background(), "key", "user", "https://example.com/v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test case: The edit fails when the journal can't be written
+	repo.journal.file.Close()
+	if _, err := repo.UpdateURL(context.Background(), "key", "user", "https://example.com/v3"); !errors.Is(err, ErrStorageUnavailable) {
+		t.Errorf("Expected ErrStorageUnavailable, got: %v", err)
+	}
+	repo.Close()
+
+	// Test case: The history has no version of the failed edit, also after a restart
+	reloaded := NewInMemoryRepo(config)
+	defer reloaded.Close()
+	if originalURL, err := reloaded.GetURL(context.Background(), "key"); err != nil || originalURL != "https://example.com/v2" {
+		t.Errorf("Expected the URL of the last successful edit, got %q and %v", originalURL, err)
+	}
+	versions, err := reloaded.GetURLVersions(context.Background(), "key", "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 2 || versions[1].OriginalURL != "https://example.com/v2" {
+		t.Errorf("Expected the 2 versions of the successful edits, got %v", versions)
+	}
+}
+
+func Test_ContextDeadline(t *testing.T) {
+	repo := NewInMemoryRepo(newMockConfig("127.0.0.1:8080", ""))
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+
+	// Test case: An expired deadline is reported as a storage timeout
+	tc := "123"
+	if _, err := repo.GetURL(ctx, tc); !errors.Is(err, ErrStorageTimeout) {
+		t.Errorf("Expected ErrStorageTimeout, got: %v", err)
+	}
+}
+
+func Test_SaveURLs(t *testing.T) {
+	repo := NewInMemoryRepo(newMockConfig("127.0.0.1:8080", ""))
+
+	existingURL, _ := urlsDomain.NewURL("https://example.com", "123", "existing", "user")
+	if err := repo.SaveURL(context.Background(), existingURL); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	// Test case: A batch with an already shortened URL gets the existing key
+	first, _ := urlsDomain.NewURL("https://example.org", "456", "first", "user")
+	second, _ := urlsDomain.NewURL("https://example.com", "789", "second", "user")
+	keys, err := repo.SaveURLs(context.Background(), []*urlsDomain.URL{first, second})
+	if err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if len(keys) != 2 || keys[0] != "456" || keys[1] != "123" {
+		t.Errorf("Expected keys [456 123], got %v", keys)
+	}
+
+	// Test case: A taken key fails the whole batch
+	third, _ := urlsDomain.NewURL("https://example.net", "abc", "third", "user")
+	fourth, _ := urlsDomain.NewURL("https://example.edu", "456", "fourth", "user")
+	if _, err := repo.SaveURLs(context.Background(), []*urlsDomain.URL{third, fourth}); !errors.Is(err, ErrKeyNotUnique) {
+		t.Errorf("Expected ErrKeyNotUnique, got: %v", err)
+	}
+	tc := "abc"
+	if _, err := repo.GetURL(context.Background(), tc); !errors.Is(err, ErrNotFoundURL) {
+		t.Errorf("Expected ErrNotFoundURL, got: %v", err)
+	}
+}
+
+// Fill the Repo with n URLs directly through the indexes, so that no file is written
+func newPopulatedRepo(n int) *InMemoryRepo {
+	repo := NewInMemoryRepo(newMockConfig("127.0.0.1:8080", ""))
+	for i := 0; i < n; i++ {
+		id := strconv.Itoa(i)
+		repo.index(&urlInFile{
+			CorrelationID: "correlation-" + id,
+			ShortURL:      "key-" + id,
+			OriginalURL:   "https://example.com/" + id,
+			UserID:        "user-" + strconv.Itoa(i%1000),
+		})
+	}
+
+	return repo
+}
+
+func BenchmarkGetURL(b *testing.B) {
+	for _, size := range []int{1_000, 1_000_000} {
+		repo := newPopulatedRepo(size)
+		ctx := context.Background()
+
+		b.Run(fmt.Sprintf("links=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.GetURL(ctx, "key-"+strconv.Itoa(i%size)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(fmt.Sprintf("links=%d/parallel", size), func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					if _, err := repo.GetURL(ctx, "key-"+strconv.Itoa(i%size)); err != nil {
+						b.Fatal(err)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkSaveURL(b *testing.B) {
+	for _, size := range []int{1_000, 1_000_000} {
+		repo := newPopulatedRepo(size)
+		ctx := context.Background()
+
+		// The benchmark function runs several times, so the IDs keep growing between the runs
+		next := size
+		b.Run(fmt.Sprintf("links=%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				id := strconv.Itoa(next)
+				next++
+				url, _ := urlsDomain.NewURL("https://example.com/"+id, "key-"+id, "correlation-"+id, "user")
+				if err := repo.SaveURL(ctx, url); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
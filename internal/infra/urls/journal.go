@@ -0,0 +1,233 @@
+package urls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.uber.org/zap"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+)
+
+// The journal is an append-only file with one JSON record per line. Every change of the Repo is appended to it
+// and the journal of URLs is compacted into a snapshot of the current URLs from time to time
+type journal struct {
+	path   string
+	policy string
+	file   *os.File
+	// The number of records appended since the last compaction
+	appended int
+	unsynced bool
+	mu       sync.Mutex
+}
+
+// Open the journal for appending. The file is created if it doesn't exist yet
+func openJournal(path string, policy string) (*journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &journal{
+		path:   path,
+		policy: policy,
+		file:   file,
+	}
+
+	// A truncated trailing line must not be glued to the next record
+	if err := j.terminateLastLine(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+func (j *journal) terminateLastLine() error {
+	info, err := j.file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+
+	last := make([]byte, 1)
+	if _, err := j.file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+
+	_, err = j.file.Write([]byte{'\n'})
+	return err
+}
+
+// Append the records with a single write, so that a crash can only truncate the last line of the file
+func (j *journal) append(records ...any) error {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if written, err := j.file.Write(data.Bytes()); err != nil {
+		if written > 0 {
+			j.discardPartialWrite(written)
+		}
+		return err
+	}
+	j.appended += len(records)
+
+	if j.policy == conf.FsyncAlways {
+		return j.file.Sync()
+	}
+	j.unsynced = true
+
+	return nil
+}
+
+// Cut off the bytes of the failed write, otherwise the next record would be glued to the partial one.
+// If the file can't be truncated, the partial line is at least terminated and is skipped on load
+func (j *journal) discardPartialWrite(written int) {
+	info, err := j.file.Stat()
+	if err == nil {
+		err = j.file.Truncate(info.Size() - int64(written))
+	}
+	if err == nil {
+		return
+	}
+
+	logger.Log.Info("Couldn't truncate the partial record of the journal", zap.String("file", j.path), zap.Error(err))
+	if err := j.terminateLastLine(); err != nil {
+		logger.Log.Info("Couldn't terminate the partial record of the journal", zap.String("file", j.path), zap.Error(err))
+	}
+}
+
+// Flush the appended records to disk
+func (j *journal) sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.unsynced {
+		return nil
+	}
+	j.unsynced = false
+
+	return j.file.Sync()
+}
+
+func (j *journal) needsCompaction() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.appended > 0
+}
+
+// Replace the journal with the records written by the function. The old file is replaced only once the new one
+// is completely written and flushed to disk, so a crash leaves either the old journal or the new one
+func (j *journal) rewrite(write func(encoder *json.Encoder) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	tmpPath := j.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	err = write(json.NewEncoder(writer))
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, j.path)
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// The new file is already opened for appending, so it replaces the old one right away
+	j.file.Close()
+	j.file = tmpFile
+	j.appended = 0
+	j.unsynced = false
+
+	return syncDir(filepath.Dir(j.path))
+}
+
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := j.file.Sync(); err != nil {
+		j.file.Close()
+		return err
+	}
+
+	return j.file.Close()
+}
+
+// The rename is durable only once the directory itself is flushed
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	return dir.Sync()
+}
+
+// Read every record of the journal in order. The lines which can't be applied are skipped and reported,
+// the number of skipped lines is returned
+func replayJournal(path string, apply func(line []byte) error) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	skipped := 0
+	reader := bufio.NewReader(file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return skipped, err
+		}
+
+		if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 {
+			if applyErr := apply(trimmed); applyErr != nil {
+				logger.Log.Warn("Skipping a corrupted line of the storage file",
+					zap.String("file", path),
+					zap.Int("line", lineNumber),
+					zap.Bool("truncated", errors.Is(err, io.EOF)),
+					zap.Error(applyErr),
+				)
+				skipped++
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return skipped, nil
+		}
+	}
+}
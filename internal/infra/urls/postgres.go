@@ -3,147 +3,928 @@ package urls
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
-	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/jackc/pgconn"
 
 	conf "github.com/nomardt/urlshortener-x/cmd/config"
 	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
 	"github.com/nomardt/urlshortener-x/internal/infra/logger"
+	"github.com/nomardt/urlshortener-x/internal/infra/migrations"
 	"go.uber.org/zap"
 )
 
 type PostgresRepo struct {
-	db  *sql.DB
-	ctx context.Context
+	db           *sql.DB
+	queryTimeout time.Duration
 }
 
-func NewPostgresRepo(config conf.Configuration) *PostgresRepo {
-	postgresRepo := &PostgresRepo{}
+// Connect to the database of the DSN and bring its schema up to date
+func NewPostgresRepo(dsn string, config conf.Configuration) (*PostgresRepo, error) {
+	postgresRepo := &PostgresRepo{
+		queryTimeout: config.QueryTimeout,
+	}
 
-	if err := postgresRepo.initializeDB(config); err != nil {
+	if err := postgresRepo.initializeDB(dsn, config); err != nil {
 		logger.Log.Info("Error when initializing DB", zap.Error(err))
-		log.Fatal(err)
+		return nil, err
 	}
 
-	if err := postgresRepo.loadTable(); err != nil {
-		logger.Log.Info("Error when loading table urls", zap.Error(err))
-		log.Fatal(err)
+	if err := postgresRepo.migrate(); err != nil {
+		logger.Log.Info("Error when migrating the database", zap.Error(err))
+		postgresRepo.db.Close()
+		return nil, err
 	}
 
-	return postgresRepo
+	return postgresRepo, nil
 }
 
 // Add the specified URL to the Repo
-func (r *PostgresRepo) SaveURL(url *urlsDomain.URL) error {
-	tx, err := r.db.BeginTx(r.ctx, nil)
+func (r *PostgresRepo) SaveURL(ctx context.Context, url *urlsDomain.URL) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		logger.Log.Info("Couldn't begin transaction", zap.Error(err))
-		return err
+		return wrapDBError(err)
 	}
 	defer tx.Rollback() //nolint:all
 
 	// Checking if the provided correlation ID is unique
-	stmtCheckCorID, err := tx.PrepareContext(r.ctx, "SELECT full_uri FROM urls WHERE id = $1")
+	stmtCheckCorID, err := tx.PrepareContext(ctx, "SELECT full_uri FROM urls WHERE id = $1")
 	if err != nil {
 		logger.Log.Info("Couldn't prepare SELECT context", zap.Error(err))
-		return err
+		return wrapDBError(err)
 	}
 	defer stmtCheckCorID.Close()
 
-	err = stmtCheckCorID.QueryRowContext(r.ctx, url.CorrelationID()).Scan(nil)
-	if !errors.Is(err, sql.ErrNoRows) {
-		logger.Log.Info("The specified correlation ID is not unique", zap.String("correlation_id", url.CorrelationID()), zap.Error(err))
+	var existingURI string
+	err = stmtCheckCorID.QueryRowContext(ctx, url.CorrelationID()).Scan(&existingURI)
+	if err == nil {
+		logger.Log.Info("The specified correlation ID is not unique", zap.String("correlation_id", url.CorrelationID()))
 		return ErrCorIDNotUnique
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		logger.Log.Info("Couldn't check the correlation ID", zap.Error(err))
+		return wrapDBError(err)
 	}
 
 	// Checking if the provided full_uri is unique
-	stmtCheckFullURI, err := tx.PrepareContext(r.ctx, "SELECT key FROM urls WHERE full_uri = $1")
+	stmtCheckFullURI, err := tx.PrepareContext(ctx, "SELECT key FROM urls WHERE full_uri = $1")
 	if err != nil {
 		logger.Log.Info("Couldn't prepare SELECT context", zap.Error(err))
-		return err
+		return wrapDBError(err)
 	}
 	defer stmtCheckFullURI.Close()
 
 	var oldKey string
-	err = stmtCheckFullURI.QueryRowContext(r.ctx, url.LongURL()).Scan(&oldKey)
-	if !errors.Is(err, sql.ErrNoRows) {
-		logger.Log.Info("The specified full URL is not unique", zap.String("full_uri", url.LongURL()), zap.Error(err))
+	err = stmtCheckFullURI.QueryRowContext(ctx, url.LongURL()).Scan(&oldKey)
+	if err == nil {
+		logger.Log.Info("The specified full URL is not unique", zap.String("full_uri", url.LongURL()))
 		return newErrURINotUnique(oldKey)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		logger.Log.Info("Couldn't check the full URL", zap.Error(err))
+		return wrapDBError(err)
 	}
 
-	// Adding the newly shortened URI to the database
-	stmtAddURL, err := tx.PrepareContext(r.ctx, `
-		INSERT INTO urls (id, key, full_uri, created_at, updated_at)
-		VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-		ON CONFLICT (key) DO UPDATE
-		SET full_uri = EXCLUDED.full_uri, updated_at = CURRENT_TIMESTAMP
+	// Adding the newly shortened URI to the database, an existing URL with the same key is never overwritten
+	stmtAddURL, err := tx.PrepareContext(ctx, `
+		INSERT INTO urls (id, key, full_uri, user_id, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT (key) DO NOTHING
 	`)
 	if err != nil {
 		logger.Log.Info("Couldn't prepare INSERT context", zap.Error(err))
-		return err
+		return wrapDBError(err)
 	}
 	defer stmtAddURL.Close()
 
-	_, err = stmtAddURL.ExecContext(r.ctx, url.CorrelationID(), url.ID(), url.LongURL())
+	expiresAt := sql.NullTime{Time: url.ExpiresAt(), Valid: !url.ExpiresAt().IsZero()}
+	result, err := stmtAddURL.ExecContext(ctx, url.CorrelationID(), url.ID(), url.LongURL(), url.UserID(), expiresAt)
 	if err != nil {
 		logger.Log.Info("Couldn't execute INSERT context", zap.Error(err))
-		return err
+		return wrapDBError(err)
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return wrapDBError(err)
+	} else if inserted == 0 {
+		logger.Log.Info("The specified key is already taken", zap.String("key", url.ID()))
+		return ErrKeyNotUnique
 	}
 
-	return tx.Commit()
+	return wrapDBError(tx.Commit())
+}
+
+// Add all the specified URLs to the database in a single transaction. The returned keys are in the order of the URLs,
+// a URL whose full URI is already stored gets the existing key
+func (r *PostgresRepo) SaveURLs(ctx context.Context, urls []*urlsDomain.URL) ([]string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	correlationIDs := make([]string, 0, len(urls))
+	fullURIs := make([]string, 0, len(urls))
+	for _, url := range urls {
+		correlationIDs = append(correlationIDs, url.CorrelationID())
+		fullURIs = append(fullURIs, url.LongURL())
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log.Info("Couldn't begin transaction", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+	defer tx.Rollback() //nolint:all
+
+	// Checking if the provided correlation IDs are unique
+	var existingID string
+	err = tx.QueryRowContext(ctx, "SELECT id FROM urls WHERE id = ANY($1::text[]) LIMIT 1", correlationIDs).Scan(&existingID)
+	if err == nil {
+		logger.Log.Info("The specified correlation ID is not unique", zap.String("correlation_id", existingID))
+		return nil, ErrCorIDNotUnique
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		logger.Log.Info("Couldn't check the correlation IDs", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+
+	// The full URIs which are already shortened aren't saved again
+	rows, err := tx.QueryContext(ctx, "SELECT full_uri, key FROM urls WHERE full_uri = ANY($1::text[])", fullURIs)
+	if err != nil {
+		logger.Log.Info("Couldn't check the full URLs", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+	existingKeys := make(map[string]string)
+	for rows.Next() {
+		var fullURI, key string
+		if err := rows.Scan(&fullURI, &key); err != nil {
+			rows.Close()
+			return nil, wrapDBError(err)
+		}
+		existingKeys[fullURI] = key
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, wrapDBError(err)
+	}
+
+	keys := make([]string, len(urls))
+	seenCorIDs := make(map[string]struct{}, len(urls))
+	var newCorIDs, newKeys, newURIs, newUserIDs []string
+	var newExpiresAt []*time.Time
+	for i, url := range urls {
+		if _, duplicate := seenCorIDs[url.CorrelationID()]; duplicate {
+			logger.Log.Info("The specified correlation ID is not unique", zap.String("correlation_id", url.CorrelationID()))
+			return nil, ErrCorIDNotUnique
+		}
+		seenCorIDs[url.CorrelationID()] = struct{}{}
+
+		if key, found := existingKeys[url.LongURL()]; found {
+			keys[i] = key
+			continue
+		}
+		existingKeys[url.LongURL()] = url.ID()
+		keys[i] = url.ID()
+
+		newCorIDs = append(newCorIDs, url.CorrelationID())
+		newKeys = append(newKeys, url.ID())
+		newURIs = append(newURIs, url.LongURL())
+		newUserIDs = append(newUserIDs, url.UserID())
+		if expiresAt := url.ExpiresAt(); expiresAt.IsZero() {
+			newExpiresAt = append(newExpiresAt, nil)
+		} else {
+			newExpiresAt = append(newExpiresAt, &expiresAt)
+		}
+	}
+
+	// Inserting all the new URLs with a single statement, a taken key fails the whole batch
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO urls (id, key, full_uri, user_id, expires_at, created_at, updated_at)
+		SELECT id, key, full_uri, user_id, expires_at, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+		FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::timestamptz[]) AS batch(id, key, full_uri, user_id, expires_at)
+		ON CONFLICT (key) DO NOTHING
+	`, newCorIDs, newKeys, newURIs, newUserIDs, newExpiresAt)
+	if err != nil {
+		logger.Log.Info("Couldn't insert the batch of URLs", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return nil, wrapDBError(err)
+	} else if int(inserted) != len(newKeys) {
+		logger.Log.Info("Some of the specified keys are already taken", zap.Int("taken", len(newKeys)-int(inserted)))
+		return nil, ErrKeyNotUnique
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, wrapDBError(err)
+	}
+
+	return keys, nil
 }
 
 // Check if there is a URL stored in the Repo with the specified ID
-func (r *PostgresRepo) GetURL(key *string) (string, error) {
-	tx, err := r.db.BeginTx(r.ctx, nil)
+func (r *PostgresRepo) GetURL(ctx context.Context, key string) (string, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return "", err
+		return "", wrapDBError(err)
 	}
 	defer tx.Rollback() //nolint:all
 
-	stmtGetURL, err := tx.PrepareContext(r.ctx, "SELECT full_uri FROM urls WHERE key = $1")
+	stmtGetURL, err := tx.PrepareContext(ctx, "SELECT full_uri, is_deleted, expires_at IS NOT NULL AND expires_at <= now() FROM urls WHERE key = $1")
 	if err != nil {
 		logger.Log.Info("Couldn't get full_uri with the specified key", zap.Error(err))
-		return "", err
+		return "", wrapDBError(err)
 	}
 	defer stmtGetURL.Close()
 
 	var fullURL string
-	err = stmtGetURL.QueryRowContext(r.ctx, key).Scan(&fullURL)
+	var isDeleted, isExpired bool
+	err = stmtGetURL.QueryRowContext(ctx, key).Scan(&fullURL, &isDeleted, &isExpired)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return "", ErrNotFoundURL
 		}
 
 		logger.Log.Info("Couldn't retrieve shortened URL", zap.Error(err))
-		return "", err
+		return "", wrapDBError(err)
+	}
+	if isDeleted {
+		return "", ErrDeletedURL
+	}
+	if isExpired {
+		return "", ErrExpiredURL
+	}
+
+	return fullURL, wrapDBError(tx.Commit())
+}
+
+// Get all the URLs shortened by the specified user
+func (r *PostgresRepo) GetURLsByUser(ctx context.Context, userID string) ([]*urlsDomain.URL, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, key, full_uri, expires_at FROM urls
+		WHERE user_id = $1 AND NOT is_deleted AND (expires_at IS NULL OR expires_at > now())
+	`, userID)
+	if err != nil {
+		logger.Log.Info("Couldn't retrieve the user's URLs", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+	defer rows.Close()
+
+	userURLs := make([]*urlsDomain.URL, 0)
+	for rows.Next() {
+		var correlationID, key, fullURI string
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&correlationID, &key, &fullURI, &expiresAt); err != nil {
+			return nil, wrapDBError(err)
+		}
+
+		u, err := urlsDomain.NewURL(fullURI, key, correlationID, userID)
+		if err != nil {
+			logger.Log.Info("Skipping an invalid stored URL", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		if expiresAt.Valid {
+			u.SetExpiresAt(expiresAt.Time)
+		}
+		userURLs = append(userURLs, u)
+	}
+
+	return userURLs, wrapDBError(rows.Err())
+}
+
+// Mark the specified URLs as deleted. Only the URLs owned by the user in the request are affected
+func (r *PostgresRepo) DeleteURLs(ctx context.Context, requests []DeleteRequest) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	userIDs := make([]string, 0, len(requests))
+	keys := make([]string, 0, len(requests))
+	for _, request := range requests {
+		userIDs = append(userIDs, request.UserID)
+		keys = append(keys, request.Key)
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE urls SET is_deleted = TRUE, updated_at = CURRENT_TIMESTAMP
+		FROM unnest($1::text[], $2::text[]) AS deleted(user_id, key)
+		WHERE urls.user_id = deleted.user_id AND urls.key = deleted.key
+	`, userIDs, keys)
+	if err != nil {
+		logger.Log.Info("Couldn't delete URLs", zap.Error(err))
+		return wrapDBError(err)
+	}
+
+	return nil
+}
+
+// Call fn with the batches of the stored URLs in the order of their keys, starting after the specified key.
+// Every batch is read with its own query, so fn is free to write to the database
+func (r *PostgresRepo) ScanStoredURLs(ctx context.Context, after string, batchSize int, fn func([]StoredURL) error) error {
+	return r.scanStoredURLs(ctx, nil, after, batchSize, fn)
+}
+
+// The same as ScanStoredURLs but only for the URLs owned by the user
+func (r *PostgresRepo) ScanUserURLs(ctx context.Context, userID string, after string, batchSize int, fn func([]StoredURL) error) error {
+	return r.scanStoredURLs(ctx, &userID, after, batchSize, fn)
+}
+
+// The URLs are read with the keyset pagination, nil userID stands for every user
+func (r *PostgresRepo) scanStoredURLs(ctx context.Context, userID *string, after string, batchSize int, fn func([]StoredURL) error) error {
+	if batchSize <= 0 {
+		batchSize = defaultScanBatchSize
+	}
+
+	for {
+		urls, err := r.getStoredURLs(ctx, userID, after, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(urls) == 0 {
+			return nil
+		}
+		if err := fn(urls); err != nil {
+			return err
+		}
+		if len(urls) < batchSize {
+			return nil
+		}
+		after = urls[len(urls)-1].Key
+	}
+}
+
+func (r *PostgresRepo) getStoredURLs(ctx context.Context, userID *string, after string, limit int) ([]StoredURL, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT key, full_uri, id, COALESCE(user_id, ''), is_deleted, expires_at FROM urls
+		WHERE key > $1 AND full_uri IS NOT NULL AND ($3::text IS NULL OR user_id = $3)
+		ORDER BY key LIMIT $2
+	`, after, limit, userID)
+	if err != nil {
+		logger.Log.Info("Couldn't read the stored URLs", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+	defer rows.Close()
+
+	urls := make([]StoredURL, 0, limit)
+	for rows.Next() {
+		var url StoredURL
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&url.Key, &url.OriginalURL, &url.CorrelationID, &url.UserID, &url.IsDeleted, &expiresAt); err != nil {
+			return nil, wrapDBError(err)
+		}
+		if expiresAt.Valid {
+			url.ExpiresAt = &expiresAt.Time
+		}
+		urls = append(urls, url)
+	}
+
+	return urls, wrapDBError(rows.Err())
+}
+
+// Save the URLs exactly as they are. The results are in the order of the URLs: nil if the URL is saved,
+// ErrURLExists if the identical URL is already stored or the error of the conflict
+func (r *PostgresRepo) SaveStoredURLs(ctx context.Context, urls []StoredURL) ([]error, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	keys := make([]string, 0, len(urls))
+	correlationIDs := make([]string, 0, len(urls))
+	fullURIs := make([]string, 0, len(urls))
+	for _, url := range urls {
+		keys = append(keys, url.Key)
+		correlationIDs = append(correlationIDs, url.CorrelationID)
+		fullURIs = append(fullURIs, url.OriginalURL)
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log.Info("Couldn't begin transaction", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+	defer tx.Rollback() //nolint:all
+
+	// Every stored URL the batch may conflict with
+	rows, err := tx.QueryContext(ctx, `
+		SELECT key, full_uri, id, is_deleted FROM urls
+		WHERE key = ANY($1::text[]) OR id = ANY($2::text[]) OR full_uri = ANY($3::text[])
+	`, keys, correlationIDs, fullURIs)
+	if err != nil {
+		logger.Log.Info("Couldn't check the stored URLs", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+	index := newStoredIndex()
+	for rows.Next() {
+		var existing StoredURL
+		var key, fullURI sql.NullString
+		if err := rows.Scan(&key, &fullURI, &existing.CorrelationID, &existing.IsDeleted); err != nil {
+			rows.Close()
+			return nil, wrapDBError(err)
+		}
+		existing.Key, existing.OriginalURL = key.String, fullURI.String
+		index.add(existing)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, wrapDBError(err)
+	}
+	batch := index.checkBatch(urls)
+
+	if len(batch.newURLs) > 0 {
+		var newCorIDs, newKeys, newURIs, newUserIDs []string
+		var newDeleted []bool
+		var newExpiresAt []*time.Time
+		for _, url := range batch.newURLs {
+			newCorIDs = append(newCorIDs, url.CorrelationID)
+			newKeys = append(newKeys, url.Key)
+			newURIs = append(newURIs, url.OriginalURL)
+			newUserIDs = append(newUserIDs, url.UserID)
+			newDeleted = append(newDeleted, url.IsDeleted)
+			newExpiresAt = append(newExpiresAt, url.ExpiresAt)
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO urls (id, key, full_uri, user_id, is_deleted, expires_at, created_at, updated_at)
+			SELECT id, key, full_uri, user_id, is_deleted, expires_at, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+			FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::boolean[], $6::timestamptz[])
+				AS batch(id, key, full_uri, user_id, is_deleted, expires_at)
+			ON CONFLICT DO NOTHING
+		`, newCorIDs, newKeys, newURIs, newUserIDs, newDeleted, newExpiresAt)
+		if err != nil {
+			logger.Log.Info("Couldn't insert the stored URLs", zap.Error(err))
+			return nil, wrapDBError(err)
+		}
+		// Nothing but the URLs saved by someone else since the check can be skipped
+		if inserted, err := result.RowsAffected(); err != nil {
+			return nil, wrapDBError(err)
+		} else if int(inserted) != len(newKeys) {
+			logger.Log.Info("The URLs were saved concurrently with the batch", zap.Int("skipped", len(newKeys)-int(inserted)))
+			return nil, ErrKeyNotUnique
+		}
+	}
+
+	if len(batch.deletedKeys) > 0 {
+		_, err := tx.ExecContext(ctx, "UPDATE urls SET is_deleted = TRUE, updated_at = CURRENT_TIMESTAMP WHERE key = ANY($1::text[])", batch.deletedKeys)
+		if err != nil {
+			logger.Log.Info("Couldn't delete the stored URLs", zap.Error(err))
+			return nil, wrapDBError(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, wrapDBError(err)
+	}
+
+	return batch.results, nil
+}
+
+// Remove the expired URLs from the database
+func (r *PostgresRepo) DeleteExpiredURLs(ctx context.Context) (int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	// The history and the clicks go along with the URL, so the key can be taken again without inheriting them
+	result, err := r.db.ExecContext(ctx, `
+		WITH removed AS (DELETE FROM urls WHERE expires_at IS NOT NULL AND expires_at <= now() RETURNING key),
+		removed_versions AS (DELETE FROM url_versions WHERE key IN (SELECT key FROM removed)),
+		removed_clicks AS (DELETE FROM clicks WHERE key IN (SELECT key FROM removed))
+		SELECT key FROM removed
+	`)
+	if err != nil {
+		logger.Log.Info("Couldn't delete expired URLs", zap.Error(err))
+		return 0, wrapDBError(err)
+	}
+
+	removed, err := result.RowsAffected()
+	return int(removed), wrapDBError(err)
+}
+
+// Record the clicks with a single statement
+func (r *PostgresRepo) SaveClicks(ctx context.Context, clicks []Click) error {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	keys := make([]string, 0, len(clicks))
+	times := make([]time.Time, 0, len(clicks))
+	referrers := make([]string, 0, len(clicks))
+	userAgents := make([]string, 0, len(clicks))
+	ipHashes := make([]string, 0, len(clicks))
+	for _, click := range clicks {
+		keys = append(keys, click.Key)
+		times = append(times, click.Time)
+		referrers = append(referrers, click.Referrer)
+		userAgents = append(userAgents, click.UserAgent)
+		ipHashes = append(ipHashes, click.IPHash)
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO clicks (key, clicked_at, referrer, user_agent, ip_hash)
+		SELECT key, clicked_at, NULLIF(referrer, ''), NULLIF(user_agent, ''), NULLIF(ip_hash, '')
+		FROM unnest($1::text[], $2::timestamptz[], $3::text[], $4::text[], $5::text[]) AS batch(key, clicked_at, referrer, user_agent, ip_hash)
+	`, keys, times, referrers, userAgents, ipHashes)
+	if err != nil {
+		logger.Log.Info("Couldn't record clicks", zap.Error(err))
+		return wrapDBError(err)
+	}
+
+	return nil
+}
+
+// Count the links which work and the users who own them
+func (r *PostgresRepo) GetServiceStats(ctx context.Context) (*ServiceStats, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	stats := &ServiceStats{}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT count(*), count(DISTINCT NULLIF(user_id, '')) FROM urls
+		WHERE NOT is_deleted AND (expires_at IS NULL OR expires_at > now())
+	`).Scan(&stats.URLs, &stats.Users)
+	if err != nil {
+		logger.Log.Info("Couldn't count the URLs", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+
+	return stats, nil
+}
+
+// Count every stored key, the deleted and the expired URLs keep theirs until they are swept
+func (r *PostgresRepo) CountKeys(ctx context.Context) (int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM urls").Scan(&count); err != nil {
+		logger.Log.Info("Couldn't count the keys", zap.Error(err))
+		return 0, wrapDBError(err)
+	}
+
+	return count, nil
+}
+
+// Get the click statistics of the URL owned by the user. All the numbers are read from the same snapshot
+func (r *PostgresRepo) GetURLStats(ctx context.Context, key string, userID string) (*URLStats, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, wrapDBError(err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var owner sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM urls WHERE key = $1", key).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFoundURL
+	} else if err != nil {
+		return nil, wrapDBError(err)
+	}
+	if owner.String != userID {
+		return nil, ErrNotURLOwner
 	}
 
-	return fullURL, tx.Commit()
+	stats := &URLStats{Key: key}
+	err = tx.QueryRowContext(ctx, "SELECT count(*), count(DISTINCT ip_hash) FROM clicks WHERE key = $1", key).
+		Scan(&stats.TotalClicks, &stats.UniqueVisitors)
+	if err != nil {
+		return nil, wrapDBError(err)
+	}
+
+	if stats.TopReferrers, err = queryTopCounts(ctx, tx, "referrer", key); err != nil {
+		return nil, wrapDBError(err)
+	}
+	if stats.TopUserAgents, err = queryTopCounts(ctx, tx, "user_agent", key); err != nil {
+		return nil, wrapDBError(err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT date_trunc('day', clicked_at AT TIME ZONE 'UTC') AS day, count(*)
+		FROM clicks WHERE key = $1
+		GROUP BY day ORDER BY day
+	`, key)
+	if err != nil {
+		return nil, wrapDBError(err)
+	}
+	defer rows.Close()
+
+	stats.Daily = make([]DailyClicks, 0)
+	for rows.Next() {
+		var daily DailyClicks
+		if err := rows.Scan(&daily.Date, &daily.Count); err != nil {
+			return nil, wrapDBError(err)
+		}
+		daily.Date = daily.Date.UTC()
+		stats.Daily = append(stats.Daily, daily)
+	}
+
+	return stats, wrapDBError(rows.Err())
+}
+
+// The column is one of the constant names, never the user input
+func queryTopCounts(ctx context.Context, tx *sql.Tx, column string, key string) ([]StatsCount, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT %[1]s, count(*) AS clicks
+		FROM clicks WHERE key = $1 AND %[1]s IS NOT NULL
+		GROUP BY %[1]s ORDER BY clicks DESC, %[1]s
+		LIMIT $2
+	`, column), key, topStatsSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	top := make([]StatsCount, 0)
+	for rows.Next() {
+		var count StatsCount
+		if err := rows.Scan(&count.Value, &count.Count); err != nil {
+			return nil, err
+		}
+		top = append(top, count)
+	}
+
+	return top, rows.Err()
+}
+
+// Point the URL owned by the user at the new destination. The change is recorded as a new version
+func (r *PostgresRepo) UpdateURL(ctx context.Context, key string, userID string, originalURL string) (*URLVersion, error) {
+	return r.changeURL(ctx, key, userID, func(tx *sql.Tx) (string, error) {
+		return originalURL, nil
+	})
+}
+
+// Point the URL owned by the user back at the destination of the version. The rollback is recorded
+// as a new version, the history itself is never rewritten
+func (r *PostgresRepo) RollbackURL(ctx context.Context, key string, userID string, version int) (*URLVersion, error) {
+	return r.changeURL(ctx, key, userID, func(tx *sql.Tx) (string, error) {
+		if version == 1 {
+			// The first version is recorded on the first edit, before that it is the current destination
+			var originalURL string
+			err := tx.QueryRowContext(ctx, `
+				SELECT COALESCE((SELECT full_uri FROM url_versions WHERE key = $1 AND version = 1), full_uri)
+				FROM urls WHERE key = $1
+			`, key).Scan(&originalURL)
+			return originalURL, err
+		}
+
+		var originalURL string
+		err := tx.QueryRowContext(ctx, "SELECT full_uri FROM url_versions WHERE key = $1 AND version = $2", key, version).Scan(&originalURL)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNotFoundVersion
+		}
+		return originalURL, err
+	})
+}
+
+// The row of the URL stays locked until the change is committed, so the concurrent edits get consecutive versions
+func (r *PostgresRepo) changeURL(ctx context.Context, key string, userID string, destination func(tx *sql.Tx) (string, error)) (*URLVersion, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		logger.Log.Info("Couldn't begin transaction", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+	defer tx.Rollback() //nolint:all
+
+	var currentURL string
+	var owner sql.NullString
+	var isDeleted, isExpired bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT full_uri, user_id, is_deleted, expires_at IS NOT NULL AND expires_at <= now()
+		FROM urls WHERE key = $1 FOR UPDATE
+	`, key).Scan(&currentURL, &owner, &isDeleted, &isExpired)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, ErrNotFoundURL
+	case err != nil:
+		return nil, wrapDBError(err)
+	case owner.String != userID:
+		return nil, ErrNotURLOwner
+	case isDeleted:
+		return nil, ErrDeletedURL
+	case isExpired:
+		return nil, ErrExpiredURL
+	}
+
+	originalURL, err := destination(tx)
+	if err != nil {
+		return nil, wrapDBError(err)
+	}
+
+	var latest int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(max(version), 0) FROM url_versions WHERE key = $1", key).Scan(&latest); err != nil {
+		return nil, wrapDBError(err)
+	}
+	if originalURL == currentURL {
+		versions, err := queryURLVersions(ctx, tx, key)
+		if err != nil {
+			return nil, wrapDBError(err)
+		}
+		return &versions[len(versions)-1], wrapDBError(tx.Commit())
+	}
+
+	var existingKey string
+	err = tx.QueryRowContext(ctx, "SELECT key FROM urls WHERE full_uri = $1", originalURL).Scan(&existingKey)
+	if err == nil {
+		logger.Log.Info("The specified full URL is not unique", zap.String("full_uri", originalURL))
+		return nil, newErrURINotUnique(existingKey)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return nil, wrapDBError(err)
+	}
+
+	if latest == 0 {
+		// The URL is edited for the first time, so the destination it was created with becomes the first version
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO url_versions (key, version, full_uri, created_at)
+			SELECT key, 1, full_uri, created_at AT TIME ZONE 'UTC' FROM urls WHERE key = $1
+		`, key)
+		if err != nil {
+			logger.Log.Info("Couldn't record the first version of the URL", zap.Error(err))
+			return nil, wrapDBError(err)
+		}
+		latest = 1
+	}
+
+	version := URLVersion{Key: key, Version: latest + 1, OriginalURL: originalURL}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO url_versions (key, version, full_uri, created_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		RETURNING created_at
+	`, key, version.Version, originalURL).Scan(&version.CreatedAt)
+	if err != nil {
+		logger.Log.Info("Couldn't record the version of the URL", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+	version.CreatedAt = version.CreatedAt.UTC()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE urls SET full_uri = $2, updated_at = CURRENT_TIMESTAMP WHERE key = $1", key, originalURL); err != nil {
+		logger.Log.Info("Couldn't update the URL", zap.Error(err))
+		return nil, wrapDBError(err)
+	}
+
+	return &version, wrapDBError(tx.Commit())
+}
+
+// Get every version of the URL owned by the user, the oldest one goes first
+func (r *PostgresRepo) GetURLVersions(ctx context.Context, key string, userID string) ([]URLVersion, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, wrapDBError(err)
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	var owner sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM urls WHERE key = $1", key).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFoundURL
+	} else if err != nil {
+		return nil, wrapDBError(err)
+	}
+	if owner.String != userID {
+		return nil, ErrNotURLOwner
+	}
+
+	versions, err := queryURLVersions(ctx, tx, key)
+	if err != nil {
+		return nil, wrapDBError(err)
+	}
+
+	return versions, wrapDBError(tx.Commit())
+}
+
+// The URL which has never been edited has no recorded versions, its only version is the current destination
+func queryURLVersions(ctx context.Context, tx *sql.Tx, key string) ([]URLVersion, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT version, full_uri, created_at FROM url_versions WHERE key = $1 ORDER BY version", key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []URLVersion
+	for rows.Next() {
+		version := URLVersion{Key: key}
+		var createdAt sql.NullTime
+		if err := rows.Scan(&version.Version, &version.OriginalURL, &createdAt); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			version.CreatedAt = createdAt.Time.UTC()
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(versions) > 0 {
+		return versions, nil
+	}
+
+	var originalURL string
+	var createdAt sql.NullTime
+	if err := tx.QueryRowContext(ctx, "SELECT full_uri, created_at FROM urls WHERE key = $1", key).Scan(&originalURL, &createdAt); err != nil {
+		return nil, err
+	}
+	version := initialVersion(key, originalURL, time.Time{})
+	if createdAt.Valid {
+		version.CreatedAt = createdAt.Time.UTC()
+	}
+
+	return []URLVersion{version}, nil
 }
 
 func (r *PostgresRepo) Ping(ctx context.Context) error {
-	if err := r.db.PingContext(r.ctx); err != nil {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	if err := r.db.PingContext(ctx); err != nil {
 		logger.Log.Info("Failed to ping the database", zap.Error(err))
-		return err
+		return wrapDBError(err)
 	}
 	return nil
 }
 
-func (r *PostgresRepo) initializeDB(config conf.Configuration) error {
-	ps := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		config.DB.Host, config.DB.Port, config.DB.User, config.DB.Password, config.DB.DBname, config.DB.SSLmode)
-	var err error
-	r.db, err = sql.Open("pgx", ps)
+// Close the database once nothing uses the Repo anymore
+func (r *PostgresRepo) Close() error {
+	return r.db.Close()
+}
+
+// The connection pool is shared with the rate limiter so that the instances share the limits
+func (r *PostgresRepo) DB() *sql.DB {
+	return r.db
+}
+
+// Every query gets its own deadline so that a stuck database can't hang a request forever
+func (r *PostgresRepo) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withQueryTimeout(ctx, r.queryTimeout)
+}
+
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Tell the callers apart when the database is too slow or unreachable
+func wrapDBError(err error) error {
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err):
+		return fmt.Errorf("%w: %w", ErrStorageTimeout, err)
+	case errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr):
+		return fmt.Errorf("%w: %w", ErrStorageUnavailable, err)
+	default:
+		return err
+	}
+}
+
+// Open the database specified in config and check that it is reachable
+func OpenDB(dsn string, config conf.Configuration) (*sql.DB, error) {
+	// The DSN without sslmode gets the configured one, which is disabled by default
+	if u, err := url.Parse(dsn); err == nil && !u.Query().Has("sslmode") && config.DB.SSLmode != "" {
+		query := u.Query()
+		query.Set("sslmode", config.DB.SSLmode)
+		u.RawQuery = query.Encode()
+		dsn = u.String()
+	}
+
+	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		logger.Log.Info("Couldn't open the database", zap.Error(err))
-		return err
+		return nil, err
 	}
-	// defer r.db.Close()
 
-	r.ctx = context.Background()
-	if err = r.Ping(r.ctx); err != nil {
+	ctx, cancel := withQueryTimeout(context.Background(), config.QueryTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, wrapDBError(err)
+	}
+
+	return db, nil
+}
+
+func (r *PostgresRepo) initializeDB(dsn string, config conf.Configuration) error {
+	var err error
+	r.db, err = OpenDB(dsn, config)
+	if err != nil {
 		return err
 	}
 
@@ -151,16 +932,20 @@ func (r *PostgresRepo) initializeDB(config conf.Configuration) error {
 	return nil
 }
 
-func (r *PostgresRepo) loadTable() error {
-	urlsTable := `
-		CREATE TABLE IF NOT EXISTS urls (
-			id VARCHAR(255) PRIMARY KEY DEFAULT gen_random_uuid()::text,
-			key VARCHAR(100) UNIQUE,
-			full_uri VARCHAR(1500) UNIQUE,
-			created_at TIMESTAMP,
-			updated_at TIMESTAMP
-		)
-	`
-	_, err := r.db.ExecContext(r.ctx, urlsTable)
-	return err
+// Bring the schema up to date. The migrations are applied by one instance at a time
+func (r *PostgresRepo) migrate() error {
+	migrator, err := migrations.New(r.db)
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		logger.Log.Info("Migrated the database", zap.Ints("versions", applied))
+	}
+
+	return nil
 }
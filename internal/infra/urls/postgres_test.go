@@ -0,0 +1,84 @@
+package urls
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+)
+
+// Open the Repo on the database of DATABASE_DSN in a schema of its own, which is dropped after the test
+func newTestPostgresRepo(t *testing.T) *PostgresRepo {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("DATABASE_DSN isn't set")
+	}
+
+	admin, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("urls_test_%d", time.Now().UnixNano())
+	if _, err := admin.ExecContext(context.Background(), "CREATE SCHEMA "+schema); err != nil {
+		admin.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.ExecContext(context.Background(), "DROP SCHEMA "+schema+" CASCADE") //nolint:errcheck
+		admin.Close()
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	query.Set("search_path", schema)
+	u.RawQuery = query.Encode()
+
+	repo, err := NewPostgresRepo(u.String(), newMockConfig("127.0.0.1:8080", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+
+	return repo
+}
+
+func Test_PostgresDeleteExpiredURLsPurgesClicks(t *testing.T) {
+	repo := newTestPostgresRepo(t)
+	ctx := context.Background()
+
+	expiredURL, _ := urlsDomain.NewURL("https://example.com", "key", "expired", "user")
+	expiredURL.SetExpiresAt(time.Now().Add(time.Hour))
+	if err := repo.SaveURL(ctx, expiredURL); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.SaveClicks(ctx, []Click{{Key: "key", Time: time.Now().UTC(), IPHash: "hash"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.DB().ExecContext(ctx, "UPDATE urls SET expires_at = now() - interval '1 minute' WHERE key = 'key'"); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := repo.DeleteExpiredURLs(ctx); err != nil || removed != 1 {
+		t.Fatalf("Expected 1 removed URL, got %d and %v", removed, err)
+	}
+
+	// Test case: The reused key has no clicks
+	reusedURL, _ := urlsDomain.NewURL("https://example.org", "key", "reused", "other")
+	if err := repo.SaveURL(ctx, reusedURL); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := repo.GetURLStats(ctx, "key", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TotalClicks != 0 || stats.UniqueVisitors != 0 {
+		t.Errorf("Expected no clicks, got %d clicks and %d visitors", stats.TotalClicks, stats.UniqueVisitors)
+	}
+}
@@ -0,0 +1,76 @@
+package urls
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+// The number of the most frequent referrers and user agents in the statistics
+const topStatsSize = 10
+
+type (
+	URLStats     = storage.URLStats
+	ServiceStats = storage.ServiceStats
+	StatsCount   = storage.StatsCount
+	DailyClicks  = storage.DailyClicks
+)
+
+// Aggregate the clicks of a single URL
+func newURLStats(key string, clicks []Click) *URLStats {
+	stats := &URLStats{
+		Key:         key,
+		TotalClicks: len(clicks),
+	}
+
+	visitors := make(map[string]struct{})
+	referrers := make(map[string]int)
+	userAgents := make(map[string]int)
+	daily := make(map[time.Time]int)
+	for _, click := range clicks {
+		if click.IPHash != "" {
+			visitors[click.IPHash] = struct{}{}
+		}
+		if click.Referrer != "" {
+			referrers[click.Referrer]++
+		}
+		if click.UserAgent != "" {
+			userAgents[click.UserAgent]++
+		}
+		daily[click.Time.UTC().Truncate(24*time.Hour)]++
+	}
+
+	stats.UniqueVisitors = len(visitors)
+	stats.TopReferrers = topCounts(referrers)
+	stats.TopUserAgents = topCounts(userAgents)
+
+	stats.Daily = make([]DailyClicks, 0, len(daily))
+	for date, count := range daily {
+		stats.Daily = append(stats.Daily, DailyClicks{Date: date, Count: count})
+	}
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Date.Before(stats.Daily[j].Date)
+	})
+
+	return stats
+}
+
+// The most frequent values go first, the values with the same count are sorted alphabetically
+func topCounts(counts map[string]int) []StatsCount {
+	top := make([]StatsCount, 0, len(counts))
+	for value, count := range counts {
+		top = append(top, StatsCount{Value: value, Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+
+	if len(top) > topStatsSize {
+		top = top[:topStatsSize]
+	}
+	return top
+}
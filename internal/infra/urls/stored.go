@@ -0,0 +1,85 @@
+package urls
+
+import (
+	"errors"
+
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+// The number of URLs the storage copy reads at once unless it asks for another one
+const defaultScanBatchSize = 500
+
+type StoredURL = storage.StoredURL
+
+// The URLs already stored with the same key, correlation ID or original URL
+type storedIndex struct {
+	byKey   map[string]StoredURL
+	byCorID map[string]StoredURL
+	byURI   map[string]StoredURL
+}
+
+func newStoredIndex() *storedIndex {
+	return &storedIndex{
+		byKey:   make(map[string]StoredURL),
+		byCorID: make(map[string]StoredURL),
+		byURI:   make(map[string]StoredURL),
+	}
+}
+
+func (i *storedIndex) add(url StoredURL) {
+	i.byKey[url.Key] = url
+	i.byCorID[url.CorrelationID] = url
+	i.byURI[url.OriginalURL] = url
+}
+
+// Decide if the URL can be saved. ErrURLExists is returned for the identical URL, it is what makes the copy
+// which is run again skip the URLs copied before
+func (i *storedIndex) check(url StoredURL) error {
+	if existing, found := i.byKey[url.Key]; found {
+		if existing.CorrelationID == url.CorrelationID && existing.OriginalURL == url.OriginalURL {
+			return ErrURLExists
+		}
+		return ErrKeyNotUnique
+	}
+	if _, found := i.byCorID[url.CorrelationID]; found {
+		return ErrCorIDNotUnique
+	}
+	if existing, found := i.byURI[url.OriginalURL]; found {
+		return newErrURINotUnique(existing.Key)
+	}
+
+	return nil
+}
+
+// The URLs of the batch sorted out against the stored ones
+type storedBatch struct {
+	// The result of each URL: nil if it is new, ErrURLExists if the identical URL is already stored
+	// or the error of the conflict
+	results []error
+	newURLs []StoredURL
+	// The identical URLs which are deleted in the batch but not in the Repo yet
+	deletedKeys []string
+}
+
+// Sort out the URLs of the batch. The index must hold the stored URLs the batch may conflict with,
+// the new URLs of the batch are added to it as well
+func (i *storedIndex) checkBatch(urls []StoredURL) storedBatch {
+	batch := storedBatch{results: make([]error, len(urls))}
+	for n, url := range urls {
+		err := i.check(url)
+		batch.results[n] = err
+
+		switch {
+		case err == nil:
+			i.add(url)
+			batch.newURLs = append(batch.newURLs, url)
+		case errors.Is(err, ErrURLExists) && url.IsDeleted && !i.byKey[url.Key].IsDeleted:
+			existing := i.byKey[url.Key]
+			existing.IsDeleted = true
+			i.byKey[url.Key] = existing
+			batch.deletedKeys = append(batch.deletedKeys, url.Key)
+		}
+	}
+
+	return batch
+}
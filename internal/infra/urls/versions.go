@@ -0,0 +1,22 @@
+package urls
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+type URLVersion = storage.URLVersion
+
+// The version log is kept next to the storage file, e.g. /tmp/short-url-db.versions.json
+func versionLogPath(storageFile string) string {
+	ext := filepath.Ext(storageFile)
+	return strings.TrimSuffix(storageFile, ext) + ".versions" + ext
+}
+
+// The history of the URL which has never been edited is its only destination
+func initialVersion(key string, originalURL string, createdAt time.Time) URLVersion {
+	return URLVersion{Key: key, Version: 1, OriginalURL: originalURL, CreatedAt: createdAt}
+}
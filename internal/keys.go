@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/internal/app/urls/handlers"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+// Create the key generator of the configuration. The counters continue after the highest value already issued
+func newKeyGenerator(ctx context.Context, config conf.Configuration, repo handlers.ExportRepository) (urlsDomain.KeyGenerator, error) {
+	keys, err := urlsDomain.NewKeyGenerator(config.KeyGenerator, config.KeyLength, config.KeyAlphabet)
+	if err != nil {
+		return nil, err
+	}
+
+	if counter, ok := keys.(urlsDomain.CountingKeyGenerator); ok {
+		next, err := nextCounterValue(ctx, counter, repo)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't read the stored keys for the key generator: %w", err)
+		}
+		counter.StartAt(next)
+	}
+
+	return keys, nil
+}
+
+// The highest value is found among all the stored keys, including the ones of the deleted and the expired links.
+// The number of the links isn't enough, since the links may be deleted after the keys after them are issued.
+// The keys of the swept links may be issued again, nothing uses them anymore
+func nextCounterValue(ctx context.Context, counter urlsDomain.CountingKeyGenerator, repo handlers.ExportRepository) (uint64, error) {
+	var next uint64
+	err := repo.ScanStoredURLs(ctx, "", 0, func(urls []storage.StoredURL) error {
+		for _, url := range urls {
+			if n, ok := counter.Index(url.Key); ok && n >= next {
+				next = n + 1
+			}
+		}
+		return nil
+	})
+
+	return next, err
+}
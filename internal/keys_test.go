@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+)
+
+func Test_NewKeyGenerator(t *testing.T) {
+	config := conf.Configuration{KeyGenerator: urlsDomain.KeyGeneratorSequential, KeyLength: 3, KeyAlphabet: "0123456789"}
+	repo := urlsInfra.NewInMemoryRepo(config)
+
+	keys, err := newKeyGenerator(context.Background(), config, repo)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		url, err := urlsDomain.NewURLWithGeneratedKey(keys, "https://example.com/"+string(rune('a'+i)), string(rune('a'+i)), "user")
+		require.NoError(t, err)
+		require.NoError(t, repo.SaveURL(context.Background(), url))
+	}
+	require.NoError(t, repo.DeleteURLs(context.Background(), []urlsInfra.DeleteRequest{{UserID: "user", Key: "000"}}))
+
+	// The counter continues after the highest key even though some links are deleted
+	keys, err = newKeyGenerator(context.Background(), config, repo)
+	require.NoError(t, err)
+	key, err := keys.NextKey()
+	require.NoError(t, err)
+	assert.Equal(t, "003", key)
+}
@@ -2,40 +2,82 @@ package internal
 
 import (
 	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 
 	conf "github.com/nomardt/urlshortener-x/cmd/config"
 	"github.com/nomardt/urlshortener-x/internal/app/urls"
-	"github.com/nomardt/urlshortener-x/internal/app/urls/handlers"
 	"github.com/nomardt/urlshortener-x/internal/infra/logger"
-	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/nomardt/urlshortener-x/internal/infra/metrics"
+	"github.com/nomardt/urlshortener-x/internal/infra/ratelimit"
+	"github.com/nomardt/urlshortener-x/pkg/storage"
 )
 
+// How long the client may take to send the headers, so that the slow clients can't hold the connections forever
+const readHeaderTimeout = 10 * time.Second
+
 func Run(config conf.Configuration) error {
-	if err := logger.Initialize("info"); err != nil {
+	if err := logger.Initialize(config.LogLevel); err != nil {
 		return err
 	}
 
+	var tlsConfig *tls.Config
+	if config.EnableHTTPS {
+		certsCtx, stopCerts := context.WithCancel(context.Background())
+		defer stopCerts()
+
+		var err error
+		if tlsConfig, err = newTLSConfig(certsCtx, config); err != nil {
+			return err
+		}
+	}
+
 	router := chi.NewRouter()
 
-	router.Use(middleware.AllowContentType("text/plain", "application/json", "application/x-gzip"))
+	router.Use(metrics.WithMetrics)
+	router.Use(middleware.AllowContentType("text/plain", "application/json", "application/x-gzip", "application/x-ndjson", "application/ndjson", "text/csv"))
 	router.Use(middleware.Compress(3))
 
-	var urlsRepo handlers.Repository
-	if config.DB.Host != "" {
-		urlsRepo = urlsInfra.NewPostgresRepo(config)
-	} else {
-		urlsRepo = urlsInfra.NewInMemoryRepo(config)
+	repo, err := storage.Open(config.StorageDSN(), config)
+	if err != nil {
+		return err
+	}
+
+	var setupOpts []urls.Option
+	if config.RateLimit > 0 && config.RateLimitStore == conf.RateLimitStorePostgres {
+		// Only the PostgreSQL storage can share its connections with the rate limiter
+		dbRepo, ok := repo.(interface{ DB() *sql.DB })
+		if !ok {
+			return errors.Join(errors.New("the postgres rate limit store requires the postgres storage"), repo.Close())
+		}
+		limit := ratelimit.PerMinute(config.RateLimit, config.RateBurst)
+		setupOpts = append(setupOpts, urls.WithRateLimitStore(ratelimit.NewPostgresStore(dbRepo.DB(), limit)))
+	}
+	urlsRepo := urls.WithMetrics(repo)
+
+	keys, err := newKeyGenerator(context.Background(), config, urlsRepo)
+	if err != nil {
+		return errors.Join(err, repo.Close())
 	}
+	setupOpts = append(setupOpts, urls.WithKeyGenerator(keys))
 
 	router.Get("/ping", logger.WithLogging(func(w http.ResponseWriter, r *http.Request) {
-		if err := urlsRepo.Ping(context.TODO()); err != nil {
+		if err := urlsRepo.Ping(r.Context()); err != nil {
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		} else {
@@ -44,12 +86,124 @@ func Run(config conf.Configuration) error {
 		}
 	}))
 
-	urls.Setup(router, urlsRepo, config)
+	router.Handle("/metrics", promhttp.Handler())
 
-	logger.Log.Info("The server has started", zap.String("address", config.ListenAddress))
-	if err := http.ListenAndServe(config.ListenAddress, router); err != nil {
-		return err
+	handler := urls.Setup(router, urlsRepo, config, setupOpts...)
+
+	sweeperCtx, stopSweeper := context.WithCancel(context.Background())
+	sweeperDone := make(chan struct{})
+	go func() {
+		defer close(sweeperDone)
+		urls.RunSweeper(sweeperCtx, urlsRepo, config.SweepInterval)
+	}()
+	keySpaceMeterDone := make(chan struct{})
+	go func() {
+		defer close(keySpaceMeterDone)
+		urls.RunKeySpaceMeter(sweeperCtx, urlsRepo, keys, config.SweepInterval)
+	}()
+
+	server := &http.Server{
+		Addr:              config.ListenAddress,
+		Handler:           router,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+	servers := []*http.Server{server}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 3)
+	go func() {
+		if tlsConfig != nil {
+			logger.Log.Info("The HTTPS server has started", zap.String("address", config.ListenAddress))
+			serverErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		logger.Log.Info("The server has started", zap.String("address", config.ListenAddress))
+		serverErr <- server.ListenAndServe()
+	}()
+
+	if config.HTTPRedirectAddress != "" {
+		redirectServer := &http.Server{
+			Addr:              config.HTTPRedirectAddress,
+			Handler:           redirectToHTTPS(config),
+			ReadHeaderTimeout: readHeaderTimeout,
+		}
+		servers = append(servers, redirectServer)
+		go func() {
+			logger.Log.Info("The HTTP to HTTPS redirect has started", zap.String("address", config.HTTPRedirectAddress))
+			serverErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	var grpcServer *grpc.Server
+	if config.GRPCAddress != "" {
+		grpcServer = urls.SetupGRPC(handler, config)
+		go func() {
+			listener, err := net.Listen("tcp", config.GRPCAddress)
+			if err != nil {
+				serverErr <- err
+				return
+			}
+			logger.Log.Info("The gRPC server has started", zap.String("address", config.GRPCAddress))
+			serverErr <- grpcServer.Serve(listener)
+		}()
+	}
+
+	var runErr error
+	select {
+	case runErr = <-serverErr:
+		logger.Log.Info("The server has failed", zap.Error(runErr))
+	case <-ctx.Done():
+		logger.Log.Info("Shutting down the server", zap.Duration("timeout", config.ShutdownTimeout))
+	}
+	if err := shutdownServers(servers, grpcServer, config.ShutdownTimeout); err != nil {
+		runErr = errors.Join(runErr, err)
+	}
+
+	// Nothing may use the storage once it is closed, so it goes last
+	stopSweeper()
+	<-sweeperDone
+	<-keySpaceMeterDone
+	handler.Close()
+	if err := repo.Close(); err != nil {
+		logger.Log.Info("Couldn't close the storage", zap.Error(err))
+		runErr = errors.Join(runErr, err)
+	}
+	logger.Log.Info("The server has stopped")
+
+	return runErr
+}
+
+// Wait for the in-flight requests to finish, the connections which are still open after the timeout are closed
+func shutdownServers(servers []*http.Server, grpcServer *grpc.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		defer func() {
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				logger.Log.Info("Some gRPC requests didn't finish in time")
+				grpcServer.Stop()
+			}
+		}()
+	}
+
+	var errs []error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Log.Info("Some requests didn't finish in time", zap.Error(err))
+			errs = append(errs, server.Close())
+		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
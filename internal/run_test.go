@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+)
+
+// The deletions and the clicks are written in the background, the shutdown must not lose the pending ones
+func Test_RunFlushesOnShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	config := conf.Configuration{
+		ListenAddress:   address,
+		BaseURL:         "http://" + address,
+		SecretKey:       "secret",
+		StorageFile:     filepath.Join(t.TempDir(), "short-url-db.json"),
+		FsyncPolicy:     conf.FsyncAlways,
+		LogLevel:        "error",
+		SweepInterval:   time.Hour,
+		ShutdownTimeout: 5 * time.Second,
+	}
+
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- Run(config)
+	}()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	do := func(method string, path string, contentType string, body string) (int, error) {
+		req, err := http.NewRequest(method, config.BaseURL+path, strings.NewReader(body))
+		if err != nil {
+			return 0, err
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	require.Eventually(t, func() bool {
+		status, err := do(http.MethodPost, "/?alias=clicked", "text/plain", "https://example.com/clicked")
+		return err == nil && status == http.StatusCreated
+	}, 5*time.Second, 50*time.Millisecond)
+	status, err := do(http.MethodPost, "/?alias=deleted", "text/plain", "https://example.com/deleted")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusCreated, status)
+
+	status, err = do(http.MethodGet, "/clicked", "", "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusTemporaryRedirect, status)
+	status, err = do(http.MethodDelete, "/api/user/urls", "application/json", `["deleted"]`)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusAccepted, status)
+
+	// The server shuts down before the workers write anything on their own
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGTERM))
+	select {
+	case err := <-runErr:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("The server didn't stop")
+	}
+
+	baseURL, err := url.Parse(config.BaseURL)
+	require.NoError(t, err)
+	cookies := jar.Cookies(baseURL)
+	require.NotEmpty(t, cookies)
+	userID, _, _ := strings.Cut(cookies[0].Value, ".")
+
+	repo := urlsInfra.NewInMemoryRepo(config)
+	defer repo.Close()
+
+	_, err = repo.GetURL(context.Background(), "deleted")
+	assert.ErrorIs(t, err, urlsInfra.ErrDeletedURL)
+	stats, err := repo.GetURLStats(context.Background(), "clicked", userID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TotalClicks)
+}
@@ -0,0 +1,375 @@
+// Package shortener is the Go client of the HTTP API of the URL shortener.
+//
+// The client keeps the user_id cookie the server issues on the first request, so all the links shortened
+// with the same client belong to the same user.
+package shortener
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// The link doesn't exist
+	ErrNotFound = errors.New("shortener: the link was not found")
+	// The link has been deleted or has expired
+	ErrGone = errors.New("shortener: the link has been deleted or has expired")
+)
+
+// The URL is already shortened or the alias is already taken.
+// ShortURL is the existing short URL of the same original URL, it is empty if the alias is taken
+type ConflictError struct {
+	ShortURL string
+	Message  string
+}
+
+func (e *ConflictError) Error() string {
+	if e.ShortURL != "" {
+		return "shortener: the URL is already shortened as " + e.ShortURL
+	}
+	return "shortener: conflict: " + e.Message
+}
+
+// Any other unsuccessful response of the server
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("shortener: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+type Client struct {
+	baseURL string
+	// The /ping endpoint is served at the root of the server even if the base URL has a path
+	rootURL    string
+	httpClient *http.Client
+	gzip       bool
+}
+
+type Option func(c *Client)
+
+// Use the specified HTTP client. The client gets a cookie jar if it has none
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// Compress the request bodies and ask the server to compress the responses
+func WithGzip() Option {
+	return func(c *Client) {
+		c.gzip = true
+	}
+}
+
+// Create a client of the shortener at the base URL, e.g. https://example.com/links
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("shortener: invalid base URL %q", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		rootURL:    u.Scheme + "://" + u.Host,
+		httpClient: &http.Client{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.httpClient.Jar == nil {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		c.httpClient.Jar = jar
+	}
+
+	return c, nil
+}
+
+type ShortenRequest struct {
+	URL string `json:"url"`
+	// The key of the short link, a random one is generated if it is empty
+	Alias string `json:"alias,omitempty"`
+	// Either ExpiresIn or ExpiresAt, the link never expires if neither is set
+	ExpiresIn time.Duration `json:"-"`
+	ExpiresAt *time.Time    `json:"expires_at,omitempty"`
+}
+
+type BatchRequestURL struct {
+	CorrelationID string        `json:"correlation_id"`
+	OriginalURL   string        `json:"original_url"`
+	Alias         string        `json:"alias,omitempty"`
+	ExpiresIn     time.Duration `json:"-"`
+	ExpiresAt     *time.Time    `json:"expires_at,omitempty"`
+}
+
+type BatchResponseURL struct {
+	CorrelationID string `json:"correlation_id"`
+	ShortURL      string `json:"short_url"`
+}
+
+type UserURL struct {
+	ShortURL    string `json:"short_url"`
+	OriginalURL string `json:"original_url"`
+}
+
+// The server expects the whole number of seconds
+func expiresInSeconds(expiresIn time.Duration) int64 {
+	if expiresIn <= 0 {
+		return 0
+	}
+	return int64((expiresIn + time.Second - 1) / time.Second)
+}
+
+// Shorten the URL with POST /api/shorten and get its short URL
+func (c *Client) Shorten(ctx context.Context, req ShortenRequest) (string, error) {
+	body := struct {
+		ShortenRequest
+		ExpiresIn int64 `json:"expires_in,omitempty"`
+	}{req, expiresInSeconds(req.ExpiresIn)}
+
+	var resp struct {
+		Result string `json:"result"`
+	}
+	status, err := c.doJSON(ctx, http.MethodPost, "/api/shorten", body, &resp)
+	if status == http.StatusConflict && resp.Result != "" {
+		return "", &ConflictError{ShortURL: resp.Result}
+	} else if err != nil {
+		return "", err
+	}
+
+	return resp.Result, nil
+}
+
+// Shorten the URL with POST / which takes the URL as plain text
+func (c *Client) ShortenPlain(ctx context.Context, longURL string) (string, error) {
+	resp, err := c.do(ctx, http.MethodPost, c.baseURL+"/", "text/plain", []byte(longURL))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.readBody(resp)
+	if err != nil {
+		return "", err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return string(body), nil
+	case http.StatusConflict:
+		// The body of the conflict is the existing short URL, unless the alias is taken
+		if u, err := url.Parse(string(body)); err == nil && u.Scheme != "" {
+			return "", &ConflictError{ShortURL: string(body)}
+		}
+		return "", &ConflictError{Message: strings.TrimSpace(string(body))}
+	default:
+		return "", newResponseError(resp.StatusCode, body)
+	}
+}
+
+// Shorten all the URLs or none of them. The short URLs are in the order of the request
+func (c *Client) ShortenBatch(ctx context.Context, urls []BatchRequestURL) ([]BatchResponseURL, error) {
+	type batchURL struct {
+		BatchRequestURL
+		ExpiresIn int64 `json:"expires_in,omitempty"`
+	}
+	body := make([]batchURL, 0, len(urls))
+	for _, u := range urls {
+		body = append(body, batchURL{u, expiresInSeconds(u.ExpiresIn)})
+	}
+
+	var resp []BatchResponseURL
+	if _, err := c.doJSON(ctx, http.MethodPost, "/api/shorten/batch", body, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// Get the original URL of the key without following the redirect
+func (c *Client) Resolve(ctx context.Context, key string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.baseURL+"/"+url.PathEscape(key), "", nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTemporaryRedirect {
+		return resp.Header.Get("Location"), nil
+	}
+
+	body, err := c.readBody(resp)
+	if err != nil {
+		return "", err
+	}
+	// The server answers 400 for the keys it doesn't know
+	if resp.StatusCode == http.StatusBadRequest {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSpace(string(body)))
+	}
+	return "", newResponseError(resp.StatusCode, body)
+}
+
+// Get the URLs shortened by this client
+func (c *Client) UserURLs(ctx context.Context) ([]UserURL, error) {
+	var resp []UserURL
+	status, err := c.doJSON(ctx, http.MethodGet, "/api/user/urls", nil, &resp)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNoContent {
+		return []UserURL{}, nil
+	}
+
+	return resp, nil
+}
+
+// Delete the URLs shortened by this client. The server deletes them in the background
+func (c *Client) DeleteUserURLs(ctx context.Context, keys []string) error {
+	_, err := c.doJSON(ctx, http.MethodDelete, "/api/user/urls", keys, nil)
+	return err
+}
+
+// Check that the server and its storage are available
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.do(ctx, http.MethodGet, c.rootURL+"/ping", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := c.readBody(resp)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return newResponseError(resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// Send the JSON request and decode the JSON response into out. The status code is returned along with the error,
+// the body of the conflict is decoded into out as well
+func (c *Client) doJSON(ctx context.Context, method string, path string, in any, out any) (int, error) {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+	}
+
+	resp, err := c.do(ctx, method, c.baseURL+path, "application/json", body)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := c.readBody(resp)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	isJSON := strings.Contains(resp.Header.Get("Content-Type"), "application/json")
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		if out != nil && isJSON && len(respBody) > 0 {
+			if err := json.Unmarshal(respBody, out); err != nil {
+				return resp.StatusCode, fmt.Errorf("shortener: couldn't decode the response: %w", err)
+			}
+		}
+		return resp.StatusCode, nil
+	case resp.StatusCode == http.StatusConflict && out != nil && isJSON:
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("shortener: couldn't decode the response: %w", err)
+		}
+		return resp.StatusCode, &ConflictError{}
+	default:
+		return resp.StatusCode, newResponseError(resp.StatusCode, respBody)
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, url string, contentType string, body []byte) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		if c.gzip {
+			var compressed bytes.Buffer
+			zw := gzip.NewWriter(&compressed)
+			if _, err := zw.Write(body); err != nil {
+				return nil, err
+			}
+			if err := zw.Close(); err != nil {
+				return nil, err
+			}
+			body = compressed.Bytes()
+		}
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" && body != nil {
+		req.Header.Set("Content-Type", contentType)
+		if c.gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+	}
+	if c.gzip {
+		// The transport doesn't decompress the responses once the header is set explicitly
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+
+	// The redirects of the short links are the responses themselves
+	httpClient := *c.httpClient
+	httpClient.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	return httpClient.Do(req)
+}
+
+func (c *Client) readBody(resp *http.Response) ([]byte, error) {
+	reader := resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("shortener: couldn't decompress the response: %w", err)
+		}
+		defer zr.Close()
+		reader = zr
+	}
+
+	return io.ReadAll(reader)
+}
+
+func newResponseError(statusCode int, body []byte) error {
+	message := strings.TrimSpace(string(body))
+	switch statusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %s", ErrNotFound, message)
+	case http.StatusGone:
+		return fmt.Errorf("%w: %s", ErrGone, message)
+	case http.StatusConflict:
+		return &ConflictError{Message: message}
+	default:
+		return &APIError{StatusCode: statusCode, Message: message}
+	}
+}
@@ -0,0 +1,164 @@
+package shortener_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	"github.com/nomardt/urlshortener-x/internal/app/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/nomardt/urlshortener-x/pkg/shortener"
+)
+
+// Start the real router of the shortener, the links are served under the base path if it isn't empty
+func newTestServer(t *testing.T, basePath string) *httptest.Server {
+	router := chi.NewRouter()
+	router.Use(middleware.Compress(3))
+	ts := httptest.NewServer(router)
+	t.Cleanup(ts.Close)
+
+	config := conf.Configuration{
+		ListenAddress: ts.Listener.Addr().String(),
+		BaseURL:       ts.URL + basePath,
+		StorageFile:   filepath.Join(t.TempDir(), "short-url-db.json"),
+		SecretKey:     "secret",
+	}
+	urlsRepo := urlsInfra.NewInMemoryRepo(config)
+	t.Cleanup(func() { urlsRepo.Close() })
+	router.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		if err := urlsRepo.Ping(r.Context()); err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	})
+	handler := urls.Setup(router, urlsRepo, config)
+	t.Cleanup(handler.Close)
+
+	return ts
+}
+
+func Test_Shorten(t *testing.T) {
+	ts := newTestServer(t, "")
+	ctx := context.Background()
+
+	for _, opts := range [][]shortener.Option{nil, {shortener.WithGzip()}} {
+		client, err := shortener.New(ts.URL, opts...)
+		require.NoError(t, err)
+
+		shortURL, err := client.Shorten(ctx, shortener.ShortenRequest{URL: "https://example.com/" + time.Now().String()})
+		require.NoError(t, err)
+		assert.Contains(t, shortURL, ts.URL+"/")
+	}
+
+	client, err := shortener.New(ts.URL, shortener.WithGzip())
+	require.NoError(t, err)
+
+	t.Run("Alias and expiry", func(t *testing.T) {
+		shortURL, err := client.Shorten(ctx, shortener.ShortenRequest{URL: "https://example.org", Alias: "org", ExpiresIn: time.Hour})
+		require.NoError(t, err)
+		assert.Equal(t, ts.URL+"/org", shortURL)
+
+		originalURL, err := client.Resolve(ctx, "org")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.org", originalURL)
+	})
+
+	t.Run("Already shortened URL", func(t *testing.T) {
+		_, err := client.Shorten(ctx, shortener.ShortenRequest{URL: "https://example.org"})
+		var conflict *shortener.ConflictError
+		require.ErrorAs(t, err, &conflict)
+		assert.Equal(t, ts.URL+"/org", conflict.ShortURL)
+
+		_, err = client.ShortenPlain(ctx, "https://example.org")
+		require.ErrorAs(t, err, &conflict)
+		assert.Equal(t, ts.URL+"/org", conflict.ShortURL)
+	})
+
+	t.Run("Taken alias", func(t *testing.T) {
+		_, err := client.Shorten(ctx, shortener.ShortenRequest{URL: "https://example.net", Alias: "org"})
+		var conflict *shortener.ConflictError
+		require.ErrorAs(t, err, &conflict)
+		assert.Empty(t, conflict.ShortURL)
+	})
+
+	t.Run("Invalid URL", func(t *testing.T) {
+		_, err := client.Shorten(ctx, shortener.ShortenRequest{URL: "example"})
+		var apiErr *shortener.APIError
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusBadRequest, apiErr.StatusCode)
+	})
+
+	t.Run("Unknown key", func(t *testing.T) {
+		_, err := client.Resolve(ctx, "unknown")
+		assert.ErrorIs(t, err, shortener.ErrNotFound)
+	})
+}
+
+func Test_ShortenBatch(t *testing.T) {
+	ts := newTestServer(t, "/links")
+	ctx := context.Background()
+
+	client, err := shortener.New(ts.URL+"/links", shortener.WithGzip())
+	require.NoError(t, err)
+	require.NoError(t, client.Ping(ctx))
+
+	shortURLs, err := client.ShortenBatch(ctx, []shortener.BatchRequestURL{
+		{CorrelationID: "1", OriginalURL: "https://example.com", Alias: "com"},
+		{CorrelationID: "2", OriginalURL: "https://example.org", Alias: "org"},
+	})
+	require.NoError(t, err)
+	require.Len(t, shortURLs, 2)
+	assert.Equal(t, shortener.BatchResponseURL{CorrelationID: "2", ShortURL: ts.URL + "/links/org"}, shortURLs[1])
+
+	userURLs, err := client.UserURLs(ctx)
+	require.NoError(t, err)
+	assert.Len(t, userURLs, 2)
+
+	// Test case: Another client has no URLs
+	stranger, err := shortener.New(ts.URL + "/links")
+	require.NoError(t, err)
+	userURLs, err = stranger.UserURLs(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, userURLs)
+
+	// Test case: The deleted link is gone
+	require.NoError(t, client.DeleteUserURLs(ctx, []string{"com"}))
+	assert.Eventually(t, func() bool {
+		_, err := client.Resolve(ctx, "com")
+		return errors.Is(err, shortener.ErrGone)
+	}, 3*time.Second, 100*time.Millisecond)
+}
+
+func Test_OpenAPI(t *testing.T) {
+	ts := newTestServer(t, "/links")
+
+	resp, err := http.Get(ts.URL + "/openapi.json")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Servers []struct {
+			URL string `json:"url"`
+		} `json:"servers"`
+		Paths map[string]any `json:"paths"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&spec))
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+	require.Len(t, spec.Servers, 1)
+	assert.Equal(t, ts.URL+"/links", spec.Servers[0].URL)
+	for _, path := range []string{"/", "/{id}", "/api/shorten", "/api/shorten/batch", "/ping"} {
+		assert.Contains(t, spec.Paths, path)
+	}
+}
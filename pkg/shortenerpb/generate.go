@@ -0,0 +1,4 @@
+// Package shortenerpb contains the gRPC client and server of the shortener generated from api/proto/shortener.proto
+package shortenerpb
+
+//go:generate protoc --proto_path=../../api/proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative shortener.proto
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+var (
+	ErrNotScannable = errors.New("the storage can't list the stored URLs")
+	ErrNotWritable  = errors.New("the storage can't save the stored URLs as they are")
+)
+
+// The storage which can list every URL it keeps, including the deleted and the expired ones
+type Scanner interface {
+	ScanStoredURLs(ctx context.Context, after string, batchSize int, fn func([]StoredURL) error) error
+}
+
+// The storage which can save the URLs with their keys and correlation IDs
+type Writer interface {
+	SaveStoredURLs(ctx context.Context, urls []StoredURL) ([]error, error)
+}
+
+type CopyOptions struct {
+	// Only the URLs with the keys after this one are copied, it resumes the interrupted copy
+	After     string
+	BatchSize int
+}
+
+// The URL which couldn't be copied since it conflicts with another URL in the destination
+type Conflict struct {
+	URL StoredURL
+	Err error
+}
+
+type CopyReport struct {
+	Copied int
+	// The identical URLs which were already in the destination, e.g. copied before the interruption
+	Existing  int
+	Conflicts []Conflict
+	// The last key of the batch which was saved completely. The copy resumes after it
+	LastKey string
+}
+
+// Copy every URL from one storage to another in the order of the keys. The copy may be run again from
+// the start or from the last key of the report, the URLs which are already copied are skipped
+func Copy(ctx context.Context, from Repository, to Repository, options CopyOptions) (CopyReport, error) {
+	report := CopyReport{LastKey: options.After}
+	scanner, ok := from.(Scanner)
+	if !ok {
+		return report, ErrNotScannable
+	}
+	writer, ok := to.(Writer)
+	if !ok {
+		return report, ErrNotWritable
+	}
+
+	err := scanner.ScanStoredURLs(ctx, options.After, options.BatchSize, func(urls []StoredURL) error {
+		results, err := writer.SaveStoredURLs(ctx, urls)
+		if err != nil {
+			return err
+		}
+
+		for i, err := range results {
+			switch {
+			case err == nil:
+				report.Copied++
+			case errors.Is(err, ErrURLExists):
+				report.Existing++
+			default:
+				report.Conflicts = append(report.Conflicts, Conflict{URL: urls[i], Err: err})
+			}
+		}
+		report.LastKey = urls[len(urls)-1].Key
+
+		return ctx.Err()
+	})
+
+	return report, err
+}
+
+// The URL of the sample which differs in the destination
+type Mismatch struct {
+	Key    string
+	Reason string
+}
+
+type VerifyReport struct {
+	SourceCount      int
+	DestinationCount int
+	Sampled          int
+	Mismatches       []Mismatch
+}
+
+func (r VerifyReport) OK() bool {
+	return r.SourceCount == r.DestinationCount && len(r.Mismatches) == 0
+}
+
+// Compare the numbers of the URLs in both storages and check that a random sample of the URLs of the source
+// is stored in the destination exactly as it is
+func Verify(ctx context.Context, from Repository, to Repository, sampleSize int, batchSize int) (VerifyReport, error) {
+	var report VerifyReport
+	source, ok := from.(Scanner)
+	if !ok {
+		return report, ErrNotScannable
+	}
+	destination, ok := to.(Scanner)
+	if !ok {
+		return report, ErrNotScannable
+	}
+
+	// Reservoir sampling picks every URL with the same probability in a single pass
+	sample := make([]StoredURL, 0, sampleSize)
+	err := source.ScanStoredURLs(ctx, "", batchSize, func(urls []StoredURL) error {
+		for _, url := range urls {
+			report.SourceCount++
+			if len(sample) < sampleSize {
+				sample = append(sample, url)
+			} else if i := rand.Intn(report.SourceCount); i < sampleSize {
+				sample[i] = url
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Sampled = len(sample)
+
+	sampled := make(map[string]StoredURL, len(sample))
+	for _, url := range sample {
+		sampled[url.Key] = url
+	}
+	err = destination.ScanStoredURLs(ctx, "", batchSize, func(urls []StoredURL) error {
+		for _, url := range urls {
+			report.DestinationCount++
+			if expected, found := sampled[url.Key]; found {
+				if reason := compareStoredURLs(expected, url); reason != "" {
+					report.Mismatches = append(report.Mismatches, Mismatch{Key: url.Key, Reason: reason})
+				}
+				delete(sampled, url.Key)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for key := range sampled {
+		report.Mismatches = append(report.Mismatches, Mismatch{Key: key, Reason: "missing in the destination"})
+	}
+
+	return report, nil
+}
+
+// Describe the first difference of the URLs. The expiration times are compared to the microsecond,
+// which is what PostgreSQL keeps
+func compareStoredURLs(expected StoredURL, actual StoredURL) string {
+	switch {
+	case expected.OriginalURL != actual.OriginalURL:
+		return fmt.Sprintf("the original URL is %q instead of %q", actual.OriginalURL, expected.OriginalURL)
+	case expected.CorrelationID != actual.CorrelationID:
+		return fmt.Sprintf("the correlation ID is %q instead of %q", actual.CorrelationID, expected.CorrelationID)
+	case expected.UserID != actual.UserID:
+		return fmt.Sprintf("the owner is %q instead of %q", actual.UserID, expected.UserID)
+	case expected.IsDeleted != actual.IsDeleted:
+		return fmt.Sprintf("the deleted flag is %t instead of %t", actual.IsDeleted, expected.IsDeleted)
+	case !sameTime(expected.ExpiresAt, actual.ExpiresAt):
+		return "the expiration time differs"
+	}
+
+	return ""
+}
+
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Truncate(time.Microsecond).Equal(b.Truncate(time.Microsecond))
+}
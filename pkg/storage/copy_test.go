@@ -0,0 +1,107 @@
+package storage_test
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+func openFileRepo(t *testing.T, path string) storage.Repository {
+	dsn := (&url.URL{Scheme: "file", Path: path}).String()
+	repo, err := storage.Open(dsn, conf.Configuration{FsyncPolicy: conf.FsyncAlways})
+	require.NoError(t, err)
+	return repo
+}
+
+func saveURL(t *testing.T, repo storage.Repository, longURL string, key string, correlationID string) {
+	url, err := urlsDomain.NewURL(longURL, key, correlationID, "user")
+	require.NoError(t, err)
+	require.NoError(t, repo.SaveURL(context.Background(), url))
+}
+
+func Test_Copy(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	source := openFileRepo(t, filepath.Join(dir, "source.json"))
+	defer source.Close()
+	saveURL(t, source, "https://example.com/a", "key-a", "1")
+	saveURL(t, source, "https://example.com/b", "key-b", "2")
+	saveURL(t, source, "https://example.com/c", "key-c", "3")
+	saveURL(t, source, "https://example.com/d", "key-d", "4")
+	saveURL(t, source, "https://example.com/e", "key-e", "5")
+	require.NoError(t, source.DeleteURLs(ctx, []urlsInfra.DeleteRequest{{UserID: "user", Key: "key-c"}}))
+
+	destinationPath := filepath.Join(dir, "destination.json")
+	destination := openFileRepo(t, destinationPath)
+	// The identical URL copied before and the taken key
+	saveURL(t, destination, "https://example.com/a", "key-a", "1")
+	saveURL(t, destination, "https://example.com/other", "key-d", "40")
+
+	// Test case: Resuming the copy after the first key
+	report, err := storage.Copy(ctx, source, destination, storage.CopyOptions{After: "key-a", BatchSize: 2})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Copied)
+	assert.Equal(t, 0, report.Existing)
+	assert.Equal(t, "key-e", report.LastKey)
+	require.Len(t, report.Conflicts, 1)
+	assert.Equal(t, "key-d", report.Conflicts[0].URL.Key)
+	assert.ErrorIs(t, report.Conflicts[0].Err, urlsInfra.ErrKeyNotUnique)
+
+	// Test case: Running the copy again from the start
+	report, err = storage.Copy(ctx, source, destination, storage.CopyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Copied)
+	assert.Equal(t, 4, report.Existing)
+	assert.Len(t, report.Conflicts, 1)
+
+	// Test case: The sample contains every URL, so the conflicting one is found
+	verifyReport, err := storage.Verify(ctx, source, destination, 10, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 5, verifyReport.SourceCount)
+	assert.Equal(t, 5, verifyReport.DestinationCount)
+	assert.Equal(t, 5, verifyReport.Sampled)
+	assert.Equal(t, []storage.Mismatch{{Key: "key-d", Reason: `the original URL is "https://example.com/other" instead of "https://example.com/d"`}}, verifyReport.Mismatches)
+	assert.False(t, verifyReport.OK())
+
+	// The copied URLs keep their keys and the deleted flag after the restart
+	require.NoError(t, destination.Close())
+	destination = openFileRepo(t, destinationPath)
+	defer destination.Close()
+	originalURL, err := destination.GetURL(ctx, "key-b")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/b", originalURL)
+	_, err = destination.GetURL(ctx, "key-c")
+	assert.ErrorIs(t, err, urlsInfra.ErrDeletedURL)
+}
+
+func Test_CopyDeletedLater(t *testing.T) {
+	ctx := context.Background()
+	source := urlsInfra.NewInMemoryRepo(conf.Configuration{})
+	destination := urlsInfra.NewInMemoryRepo(conf.Configuration{})
+	saveURL(t, source, "https://example.com/a", "key-a", "1")
+
+	_, err := storage.Copy(ctx, source, destination, storage.CopyOptions{})
+	require.NoError(t, err)
+
+	// The URL deleted after it was copied is deleted in the destination once the copy is run again
+	require.NoError(t, source.DeleteURLs(ctx, []urlsInfra.DeleteRequest{{UserID: "user", Key: "key-a"}}))
+	report, err := storage.Copy(ctx, source, destination, storage.CopyOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Existing)
+	_, err = destination.GetURL(ctx, "key-a")
+	assert.ErrorIs(t, err, urlsInfra.ErrDeletedURL)
+
+	verifyReport, err := storage.Verify(ctx, source, destination, 10, 0)
+	require.NoError(t, err)
+	assert.True(t, verifyReport.OK())
+}
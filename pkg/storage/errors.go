@@ -0,0 +1,34 @@
+package storage
+
+import "errors"
+
+var (
+	ErrNotFoundURL     = errors.New("the URL with the specified id was not found")
+	ErrCorIDNotUnique  = errors.New("the specified correlation ID is not unique")
+	ErrDeletedURL      = errors.New("the URL with the specified id has been deleted")
+	ErrKeyNotUnique    = errors.New("the specified key is already taken")
+	ErrExpiredURL      = errors.New("the URL with the specified id has expired")
+	ErrNotURLOwner     = errors.New("the URL with the specified id belongs to another user")
+	ErrURLExists       = errors.New("the same URL is already stored")
+	ErrNotFoundVersion = errors.New("the URL has no version with the specified number")
+
+	ErrStorageTimeout     = errors.New("the storage didn't respond in time")
+	ErrStorageUnavailable = errors.New("the storage is unavailable")
+)
+
+// The original URL is already shortened, the client gets the existing short URL
+type ErrURINotUnique struct {
+	ExistingKey string
+	Msg         string
+}
+
+func (e *ErrURINotUnique) Error() string {
+	return e.Msg
+}
+
+func NewErrURINotUnique(key string) error {
+	return &ErrURINotUnique{
+		ExistingKey: key,
+		Msg:         "the specified full URI is not unique",
+	}
+}
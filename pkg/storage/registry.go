@@ -0,0 +1,88 @@
+// Package storage is the API of the storage backends of the shortener. The storage is opened by the scheme
+// of its DSN, the built-in drivers are memory://, file:///path and postgres://. Other backends implement
+// Repository and register their drivers in init, so it is enough to import them for the side effects in cmd/shortener
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+)
+
+var ErrUnknownScheme = errors.New("no storage driver is registered for the scheme")
+
+// Open the storage of the DSN. The startup errors are returned rather than handled by the driver
+type Driver func(dsn *url.URL, config conf.Configuration) (Repository, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = make(map[string]Driver)
+)
+
+// Register the driver under the scheme. It panics if the scheme is already taken, so that two backends
+// can't silently replace each other
+func Register(scheme string, driver Driver) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+
+	scheme = strings.ToLower(scheme)
+	if driver == nil {
+		panic("storage: the driver of " + scheme + " is nil")
+	}
+	if _, taken := drivers[scheme]; taken {
+		panic("storage: the driver of " + scheme + " is registered twice")
+	}
+	drivers[scheme] = driver
+}
+
+// Get the schemes of the registered drivers in the alphabetical order
+func Schemes() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+
+	schemes := make([]string, 0, len(drivers))
+	for scheme := range drivers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+
+	return schemes
+}
+
+// Check that the DSN is a URL with the scheme of a registered driver
+func Validate(dsn string) error {
+	_, _, err := lookup(dsn)
+	return err
+}
+
+// Open the storage with the driver registered under the scheme of the DSN
+func Open(dsn string, config conf.Configuration) (Repository, error) {
+	u, driver, err := lookup(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver(u, config)
+}
+
+func lookup(dsn string) (*url.URL, Driver, error) {
+	// The DSN isn't a part of the error since it may have the password in it
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return nil, nil, conf.ErrInvalidDSN
+	}
+
+	driversMu.RLock()
+	driver, found := drivers[strings.ToLower(u.Scheme)]
+	driversMu.RUnlock()
+	if !found {
+		return nil, nil, fmt.Errorf("%w %s, the known ones are %s", ErrUnknownScheme, u.Scheme, strings.Join(Schemes(), ", "))
+	}
+
+	return u, driver, nil
+}
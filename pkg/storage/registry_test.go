@@ -0,0 +1,96 @@
+package storage_test
+
+import (
+	"context"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	conf "github.com/nomardt/urlshortener-x/cmd/config"
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+	urlsInfra "github.com/nomardt/urlshortener-x/internal/infra/urls"
+	"github.com/nomardt/urlshortener-x/pkg/storage"
+)
+
+func Test_OpenFile(t *testing.T) {
+	config := conf.Configuration{FsyncPolicy: conf.FsyncAlways}
+	dsn := (&url.URL{Scheme: "file", Path: filepath.Join(t.TempDir(), "short-url-db.json")}).String()
+
+	repo, err := storage.Open(dsn, config)
+	require.NoError(t, err)
+	url, err := urlsDomain.NewURL("https://example.com", "key", "1", "user")
+	require.NoError(t, err)
+	require.NoError(t, repo.SaveURL(context.Background(), url))
+	require.NoError(t, repo.Close())
+
+	// The URLs survive the restart
+	repo, err = storage.Open(dsn, config)
+	require.NoError(t, err)
+	defer repo.Close()
+	originalURL, err := repo.GetURL(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", originalURL)
+
+	// Test case: No path
+	_, err = storage.Open("file://", config)
+	assert.ErrorIs(t, err, urlsInfra.ErrNoStorageFile)
+
+	// Test case: The file can't be opened
+	dsn = "file://" + filepath.Join(t.TempDir(), "missing", "short-url-db.json")
+	_, err = storage.Open(dsn, config)
+	assert.Error(t, err)
+}
+
+func Test_OpenMemory(t *testing.T) {
+	repo, err := storage.Open("memory://", conf.Configuration{StorageFile: "/tmp/short-url-db.json"})
+	require.NoError(t, err)
+	defer repo.Close()
+
+	// The storage without the file is healthy
+	assert.NoError(t, repo.Ping(context.Background()))
+}
+
+func Test_OpenUnknown(t *testing.T) {
+	_, err := storage.Open("redis://localhost:6379", conf.Configuration{})
+	assert.ErrorIs(t, err, storage.ErrUnknownScheme)
+
+	assert.ErrorIs(t, storage.Validate("/var/lib/shortener.json"), conf.ErrInvalidDSN)
+	assert.NoError(t, storage.Validate("memory://"))
+	assert.NoError(t, storage.Validate("POSTGRES://localhost/shortener"))
+}
+
+// The backend of another module only needs the storage package
+type customRepo struct {
+	storage.Repository
+	dsn string
+}
+
+func Test_Register(t *testing.T) {
+	storage.Register("custom", func(dsn *url.URL, config conf.Configuration) (storage.Repository, error) {
+		inner, err := storage.Open("memory://", config)
+		if err != nil {
+			return nil, err
+		}
+		return customRepo{Repository: inner, dsn: dsn.String()}, nil
+	})
+	assert.Contains(t, storage.Schemes(), "custom")
+
+	repo, err := storage.Open("custom://somewhere", conf.Configuration{})
+	require.NoError(t, err)
+	defer repo.Close()
+	assert.Equal(t, "custom://somewhere", repo.(customRepo).dsn)
+
+	shortened, err := storage.NewURL("https://example.com", "key", "1", "user")
+	require.NoError(t, err)
+	require.NoError(t, repo.SaveURL(context.Background(), shortened))
+	_, err = repo.GetURL(context.Background(), "unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFoundURL)
+
+	// Test case: The scheme is already taken
+	assert.Panics(t, func() {
+		storage.Register("memory", func(*url.URL, conf.Configuration) (storage.Repository, error) { return nil, nil })
+	})
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	urlsDomain "github.com/nomardt/urlshortener-x/internal/domain/urls"
+)
+
+// The shortened URL. Its key, original URL and correlation ID are validated when it is created
+type URL = urlsDomain.URL
+
+// Create the URL the storage has read. It fails if the URL or the key are invalid
+func NewURL(longURL string, key string, correlationID string, userID string) (*URL, error) {
+	return urlsDomain.NewURL(longURL, key, correlationID, userID)
+}
+
+// The storage of the shortened URLs. The methods report the failures with the errors of this package,
+// e.g. ErrNotFoundURL or ErrStorageUnavailable, so that the handlers respond with the right status
+type Repository interface {
+	SaveURL(ctx context.Context, url *URL) error
+	// The returned keys are in the order of the URLs, a URL whose original URL is already stored gets the existing key
+	SaveURLs(ctx context.Context, urls []*URL) ([]string, error)
+	GetURL(ctx context.Context, key string) (string, error)
+	GetURLsByUser(ctx context.Context, userID string) ([]*URL, error)
+	DeleteURLs(ctx context.Context, requests []DeleteRequest) error
+	DeleteExpiredURLs(ctx context.Context) (int, error)
+	SaveClicks(ctx context.Context, clicks []Click) error
+
+	GetURLStats(ctx context.Context, key string, userID string) (*URLStats, error)
+	GetServiceStats(ctx context.Context) (*ServiceStats, error)
+	// Every stored key is counted, including the ones of the deleted and the expired URLs
+	CountKeys(ctx context.Context) (int, error)
+
+	ScanStoredURLs(ctx context.Context, after string, batchSize int, fn func([]StoredURL) error) error
+	ScanUserURLs(ctx context.Context, userID string, after string, batchSize int, fn func([]StoredURL) error) error
+
+	UpdateURL(ctx context.Context, key string, userID string, originalURL string) (*URLVersion, error)
+	RollbackURL(ctx context.Context, key string, userID string, version int) (*URLVersion, error)
+	GetURLVersions(ctx context.Context, key string, userID string) ([]URLVersion, error)
+
+	Ping(ctx context.Context) error
+	// Flush and close the storage on shutdown
+	Close() error
+}
+
+// The URL exactly as it is stored, including the deleted and the expired ones. It is what the storage copy
+// moves between the repositories, so the keys and the correlation IDs are kept as they are
+type StoredURL struct {
+	Key           string
+	OriginalURL   string
+	CorrelationID string
+	UserID        string
+	IsDeleted     bool
+	ExpiresAt     *time.Time
+}
+
+// A request to delete the URL with the specified key on behalf of the user
+type DeleteRequest struct {
+	UserID string
+	Key    string
+}
+
+// A single resolved redirect. The client IP is never stored, only its hash
+type Click struct {
+	Key       string    `json:"key"`
+	Time      time.Time `json:"time"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+}
+
+// The click statistics of a single URL
+type URLStats struct {
+	Key            string
+	TotalClicks    int
+	UniqueVisitors int
+	TopReferrers   []StatsCount
+	TopUserAgents  []StatsCount
+	Daily          []DailyClicks
+}
+
+// The totals of the whole service, only the links which work are counted
+type ServiceStats struct {
+	URLs  int
+	Users int
+}
+
+type StatsCount struct {
+	Value string
+	Count int
+}
+
+// The number of clicks during the day in UTC
+type DailyClicks struct {
+	Date  time.Time
+	Count int
+}
+
+// A destination the URL has pointed at. Every edit and rollback adds a new version, the versions are never changed.
+// The first version is the destination the URL was created with, it is recorded on the first edit
+type URLVersion struct {
+	Key         string    `json:"key"`
+	Version     int       `json:"version"`
+	OriginalURL string    `json:"original_url"`
+	CreatedAt   time.Time `json:"created_at"`
+}